@@ -1,20 +1,77 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"github.com/sirupsen/logrus"
 	"github.com/vlaurenzano/veil/pkg"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main(){
-	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
-		storage, err := pkg.NewStorage()
-		if err != nil {
-			pkg.MessageResponse(writer, 500, "an error occurred connecting to the database")
-			return
+	storage, err := pkg.NewStorage()
+	if err != nil {
+		logrus.Fatal("Error: an error occurred connecting to the database: ", err)
+	}
+
+	if addr := pkg.Config().MetricsAddress; addr != "" {
+		if cached, ok := storage.(interface{ StatementCacheStats() pkg.StatementCacheStats }); ok {
+			expvar.Publish("statement_cache", expvar.Func(func() interface{} { return cached.StatementCacheStats() }))
+		}
+		go func() {
+			logrus.Info("Info: Serving metrics on ", addr)
+			logrus.Error(http.ListenAndServe(addr, expvar.Handler()))
+		}()
+	}
+
+	//reload the schema and the policy file on SIGHUP so new tables, columns and rules take effect without a restart
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		for range hup {
+			if reloadable, ok := storage.(interface{ ReloadSchema() *pkg.StorageError }); ok {
+				if err := reloadable.ReloadSchema(); err != nil {
+					logrus.Error("Error: reloading the schema failed: ", err)
+				} else {
+					logrus.Info("Info: Reloaded the schema")
+				}
+			}
+			if policy := pkg.Config().Policy; policy != nil {
+				if err := policy.Reload(); err != nil {
+					logrus.Error("Error: reloading the policy failed, keeping the rules in use: ", err)
+				} else {
+					logrus.Info("Info: Reloaded the policy")
+				}
+			}
 		}
+	}()
+
+	server := &http.Server{Addr: ":8080", Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 		pkg.Handler(writer, request, storage)
-	} )
+	})}
+
+	//finish in flight requests before releasing the database on shutdown
+	go func() {
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		<-stop
+		logrus.Info("Info: Shutting down veil server")
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logrus.Error("Error: ", err)
+		}
+	}()
+
 	logrus.Info("Info: Starting veil server on port 8080")
-	logrus.Fatal(http.ListenAndServe(":8080", nil))
-}
\ No newline at end of file
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		logrus.Fatal(err)
+	}
+	if err := storage.Close(); err != nil {
+		logrus.Error("Error: ", err)
+	}
+}
@@ -4,5 +4,7 @@ go 1.12
 
 require (
 	github.com/go-sql-driver/mysql v1.4.1
-	github.com/sirupsen/logrus v1.4.2 // indirect
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/sirupsen/logrus v1.4.2
 )
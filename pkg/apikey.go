@@ -0,0 +1,143 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+//the header service callers present their api key in
+const APIKeyHeader = "X-API-Key"
+
+//the columns of the api key table, keys are stored as the hex sha256 of the key so the table never holds one
+//scopes and roles are space separated, roles and last_used_at are optional
+const (
+	apiKeyHashColumn     = "key_hash"
+	apiKeyOwnerColumn    = "owner"
+	apiKeyScopesColumn   = "scopes"
+	apiKeyRolesColumn    = "roles"
+	apiKeyLastUsedColumn = "last_used_at"
+)
+
+type apiKeyEntry struct {
+	identity *Identity //the identity the key authenticates as
+	expires  time.Time //when the key must be looked up again
+}
+
+//the identities of recently verified api keys by hash, unknown keys aren't kept so new keys work at once
+//a deleted key is refused once its entry expires
+type apiKeyCache struct {
+	lock    sync.Mutex
+	entries map[string]apiKeyEntry
+}
+
+var apiKeys = apiKeyCache{entries: map[string]apiKeyEntry{}}
+
+func (c *apiKeyCache) get(hash string, now time.Time) *Identity {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.entries[hash]
+	if !ok {
+		return nil
+	}
+	if !now.Before(entry.expires) {
+		delete(c.entries, hash)
+		return nil
+	}
+	return entry.identity
+}
+
+func (c *apiKeyCache) put(hash string, identity *Identity, expires time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.entries[hash] = apiKeyEntry{identity: identity, expires: expires}
+}
+
+//the hex sha256 of the key, as stored in the api key table
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+//returns the identity of the key, or nil when no row of the api key table holds its hash
+//a key is read from storage at most once per APIKeyCacheTTL, and its use is recorded whenever it is
+func lookupAPIKey(ctx context.Context, storage Storage, conf *Configuration, key string) (*Identity, *StorageError) {
+	hash := hashAPIKey(key)
+	now := time.Now()
+	if identity := apiKeys.get(hash, now); identity != nil {
+		return identity, nil
+	}
+
+	resource := Resource{conf.APIKeyTable}
+	result, err := storage.Read(ctx, resource, MatchQuery(Record{apiKeyHashColumn: hash}, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 {
+		return nil, nil
+	}
+	row := result.Data[0]
+
+	identity := Identity{}
+	if owner, ok := row[apiKeyOwnerColumn]; ok && owner != nil {
+		identity.Subject = fmt.Sprint(owner)
+	}
+	if scopes, ok := row[apiKeyScopesColumn].(string); ok {
+		identity.Scopes = strings.Fields(scopes)
+	}
+	if roles, ok := row[apiKeyRolesColumn].(string); ok {
+		identity.Roles = strings.Fields(roles)
+	}
+
+	recordAPIKeyUse(ctx, storage, resource, row, now)
+	if conf.APIKeyCacheTTL > 0 {
+		apiKeys.put(hash, &identity, now.Add(conf.APIKeyCacheTTL))
+	}
+	return &identity, nil
+}
+
+//sets the row's last_used_at, should the table have one
+//usage is bookkeeping, so failing to record it doesn't fail the request
+func recordAPIKeyUse(ctx context.Context, storage Storage, resource Resource, row Record, now time.Time) {
+	table, err := storage.Describe(resource)
+	if err != nil || !table.hasColumn(apiKeyLastUsedColumn) {
+		return
+	}
+	update, err := table.keyOf(row)
+	if err != nil {
+		return
+	}
+	update[apiKeyLastUsedColumn] = now.UTC()
+	storage.Update(ctx, resource, update)
+}
+
+//authenticates the caller by the api key they presented
+func authenticateAPIKey(c *Context, key string) {
+	if c.Config.APIKeyTable == "" {
+		unauthorized(c, "api keys are not accepted")
+		return
+	}
+	identity, err := lookupAPIKey(c.Req.Context(), c.Storage, c.Config, key)
+	if err != nil {
+		c.Continue = false
+		MessageResponse(c.Write, err.Code, err.Message)
+		return
+	}
+	if identity == nil {
+		unauthorized(c, "invalid api key")
+		return
+	}
+	c.authenticated(identity)
+}
+
+//our filter hiding the tables veil keeps for itself, they are not resources
+func Internal(c *Context) {
+	if c.Config.APIKeyTable != "" && requestedResource(c.Req) == c.Config.APIKeyTable {
+		c.Continue = false
+		MessageResponse(c.Write, 404, "resource not found")
+	}
+}
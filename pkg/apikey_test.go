@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	keys := Resource{"veil_test_api_keys"}
+	storage := &identityRecordingStorage{MemoryStorage: newTestMemoryStorage(1)}
+	storage.AddResource(keys, []string{"key_hash", "owner", "scopes", "last_used_at"}, []string{"key_hash", "owner"})
+	storage.Create(context.Background(), keys, Record{"key_hash": hashAPIKey("service-key"), "owner": "billing", "scopes": "read write"})
+
+	defer func(table string, ttl time.Duration) {
+		Config().APIKeyTable, Config().APIKeyCacheTTL = table, ttl
+	}(Config().APIKeyTable, Config().APIKeyCacheTTL)
+	Config().APIKeyTable = keys.Identifier
+	Config().APIKeyCacheTTL = time.Minute
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handler(w, r, storage)
+	}))
+	defer ts.Close()
+
+	get := func(path string, key string) int {
+		req, _ := http.NewRequest("GET", ts.URL+path, nil)
+		req.Header.Set(APIKeyHeader, key)
+		res, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatal(e)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := get("/veil_test_resource", "service-key"); status != 200 {
+		t.Fatalf("a known api key expected a 200, got %d", status)
+	}
+	if storage.identity == nil || storage.identity.Subject != "billing" || len(storage.identity.Scopes) != 2 {
+		t.Errorf("storage expected the key's owner and scopes, got %+v", storage.identity)
+	}
+	row, _ := storage.Read(context.Background(), keys, &Query{Limit: 1})
+	if _, ok := row.Data[0]["last_used_at"].(time.Time); !ok {
+		t.Errorf("expected the key's use recorded, got %v", row.Data[0])
+	}
+
+	if status := get("/veil_test_resource", "guessed-key"); status != 401 {
+		t.Errorf("an unknown api key expected a 401, got %d", status)
+	}
+	if status := get("/veil_test_api_keys", "service-key"); status != 404 {
+		t.Errorf("the api key table expected to be hidden, got %d", status)
+	}
+	for _, path := range []string{"/x/y/veil_test_api_keys", "/x/veil_test_api_keys/1", "/veil_test%5Fapi_keys"} {
+		if status := get(path, "service-key"); status != 404 {
+			t.Errorf("the api key table expected to be hidden at %s, got %d", path, status)
+		}
+	}
+	req, _ := http.NewRequest("DELETE", ts.URL+"/x/veil_test_api_keys/1", nil)
+	req.Header.Set(APIKeyHeader, "service-key")
+	if res, e := http.DefaultClient.Do(req); e != nil || res.StatusCode != 404 {
+		t.Errorf("the api key table expected to be hidden from a nested DELETE, got %v %v", res, e)
+	} else {
+		res.Body.Close()
+	}
+	if row, _ := storage.Read(context.Background(), keys, &Query{Limit: 1}); len(row.Data) != 1 {
+		t.Errorf("a nested DELETE expected to leave the api key, got %v", row.Data)
+	}
+
+	//a revoked key is trusted until its cache entry expires
+	storage.Delete(context.Background(), keys, Record{"id": row.Data[0]["id"]})
+	if status := get("/veil_test_resource", "service-key"); status != 200 {
+		t.Errorf("a cached api key expected a 200, got %d", status)
+	}
+	apiKeys.put(hashAPIKey("service-key"), nil, time.Now())
+	if status := get("/veil_test_resource", "service-key"); status != 401 {
+		t.Errorf("a revoked api key expected a 401 once its cache entry expired, got %d", status)
+	}
+
+	//a CONNECT request has no path to take a resource from
+	con := Context{Continue: true, Req: httptest.NewRequest("CONNECT", "example.com:443", nil), Write: httptest.NewRecorder(), Config: Config()}
+	Internal(&con)
+	if !con.Continue {
+		t.Error("a request without a resource expected to be let through")
+	}
+}
@@ -0,0 +1,80 @@
+package pkg
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+//the caller a request was authenticated as
+type Identity struct {
+	Subject string                 //who the caller is, the sub claim of a token or the owner of an api key
+	Roles   []string               //the roles granted to the caller
+	Scopes  []string               //the scopes granted to the caller
+	Claims  map[string]interface{} //every verified claim of the caller's token, nil for an api key
+}
+
+type identityKey struct{}
+
+//returns a copy of ctx carrying the identity, so storage calls can see who they act for
+func WithIdentity(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+//the identity carried by ctx, or nil for an anonymous caller
+func IdentityFrom(ctx context.Context) *Identity {
+	identity, _ := ctx.Value(identityKey{}).(*Identity)
+	return identity
+}
+
+//builds an identity from verified token claims
+//roles are read from the roles claim and scopes from the space separated scope claim
+func identityFromClaims(claims map[string]interface{}) *Identity {
+	identity := Identity{Claims: claims, Roles: claimStrings(claims["roles"])}
+	identity.Subject, _ = claims["sub"].(string)
+	if scope, ok := claims["scope"].(string); ok {
+		identity.Scopes = strings.Fields(scope)
+	}
+	return &identity
+}
+
+//stops the request with a 401 asking for a bearer token
+func unauthorized(c *Context, message string) {
+	c.Continue = false
+	c.Write.Header().Set("WWW-Authenticate", "Bearer")
+	MessageResponse(c.Write, 401, message)
+}
+
+//records the caller on our context and on the request's context
+func (c *Context) authenticated(identity *Identity) {
+	c.Identity = identity
+	c.Req = c.Req.WithContext(WithIdentity(c.Req.Context(), identity))
+}
+
+//our filter to authenticate the caller by an X-API-Key header or an Authorization: Bearer json web token
+//requests without credentials continue anonymously unless AuthRequired is set
+func Authenticate(c *Context) {
+	if key := c.Req.Header.Get(APIKeyHeader); key != "" {
+		authenticateAPIKey(c, key)
+		return
+	}
+	header := c.Req.Header.Get("Authorization")
+	if header == "" {
+		if c.Config.AuthRequired {
+			unauthorized(c, "authentication required")
+		}
+		return
+	}
+
+	scheme := strings.SplitN(header, " ", 2)
+	if len(scheme) != 2 || !strings.EqualFold(scheme[0], "Bearer") {
+		unauthorized(c, "expected a bearer token")
+		return
+	}
+	claims, e := verifyJWT(strings.TrimSpace(scheme[1]), c.Config, time.Now())
+	if e != nil {
+		unauthorized(c, "invalid token: "+e.Error())
+		return
+	}
+	c.authenticated(identityFromClaims(claims))
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//the path of the batch endpoint, it shadows any table called _batch
+const BatchPath = "/_batch"
+
+//one operation of a batch, as the request it stands for
+type batchOperation struct {
+	Method      string          `json:"method"`
+	Path        string          `json:"path"`
+	Body        json.RawMessage `json:"body"`
+	ContentType string          `json:"content_type"` //overrides the batch's content type, PATCH needs one of its own
+}
+
+//captures the response of a single operation so it can be gathered into the batch response
+type batchWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *batchWriter) Header() http.Header {
+	return b.header
+}
+
+func (b *batchWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = 200
+	}
+	return b.body.Write(p)
+}
+
+func (b *batchWriter) WriteHeader(status int) {
+	b.status = status
+}
+
+//writes the responses of a batch to the client
+func writeBatch(w http.ResponseWriter, status int, responses []*Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.Encode(responses)
+}
+
+//handle a batch call, every operation runs against one transaction that is only committed if they all succeed
+//POST /_batch with [{"method": "PUT", "path": "/resource", "body": {...}}, ...] -- returns the response of each operation in order
+//should an operation fail the transaction is rolled back and the responses up to and including the failure are returned with its status
+func HandleBatch(w http.ResponseWriter, r *http.Request, storage Storage) {
+	var operations []batchOperation
+	if e := json.NewDecoder(r.Body).Decode(&operations); e != nil {
+		MessageResponse(w, 400, "payload could not be parsed, expected an array of operations")
+		return
+	}
+	for i, o := range operations {
+		if !strings.HasPrefix(o.Path, "/") || strings.HasPrefix(o.Path, BatchPath) {
+			MessageResponse(w, 400, fmt.Sprintf("operation %d has an improper path '%s'", i, o.Path))
+			return
+		}
+	}
+
+	tx, err := storage.Begin(r.Context())
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	defer tx.Close()
+
+	var responses []*Response
+	for i, o := range operations {
+		req, e := http.NewRequest(strings.ToUpper(o.Method), o.Path, bytes.NewReader(o.Body))
+		if e != nil {
+			MessageResponse(w, 400, fmt.Sprintf("operation %d could not be parsed", i))
+			return
+		}
+		//operations act on behalf of the batch's client
+		for k, v := range r.Header {
+			req.Header[k] = v
+		}
+		req.Host = r.Host
+		if o.ContentType != "" {
+			req.Header.Set("Content-Type", o.ContentType)
+		}
+		req = req.WithContext(r.Context())
+
+		recorder := batchWriter{header: http.Header{}}
+		Handler(&recorder, req, tx)
+
+		response := Response{}
+		if e := json.Unmarshal(recorder.body.Bytes(), &response); e != nil {
+			response = Response{Status: recorder.status}
+		}
+		responses = append(responses, &response)
+		if recorder.status >= 400 {
+			writeBatch(w, recorder.status, responses)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	writeBatch(w, 200, responses)
+}
@@ -0,0 +1,430 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+//the most bind values a bulk statement carries, the lowest limit of our databases (sqlite's)
+//larger bulks are split over several statements within the same transaction
+const bulkBindLimit = 32766
+
+//prefixes a storage error with the position of the record that caused it
+func itemError(i int, err *StorageError) *StorageError {
+	return &StorageError{Code: err.Code, Message: fmt.Sprintf("record %d: %s", i, err.Message), WrapsError: err.WrapsError}
+}
+
+//splits the indexes into runs small enough for a statement binding perRow values for each
+func chunks(indexes []int, perRow int) [][]int {
+	size := bulkBindLimit / perRow
+	if size < 1 {
+		size = 1
+	}
+	var runs [][]int
+	for len(indexes) > size {
+		runs = append(runs, indexes[:size])
+		indexes = indexes[size:]
+	}
+	return append(runs, indexes)
+}
+
+//groups the indexes of records holding the same columns, in order of first appearance
+//a multi row insert names its columns once, so only records of the same shape share one
+func groupByColumns(records Records) ([][]string, [][]int) {
+	var shapes [][]string
+	var groups [][]int
+	seen := map[string]int{}
+	for i, record := range records {
+		columns := sortedKeys(record)
+		key := strings.Join(columns, "\x00")
+		g, ok := seen[key]
+		if !ok {
+			g = len(groups)
+			seen[key] = g
+			shapes = append(shapes, columns)
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return shapes, groups
+}
+
+//reads the keys of the rows meeting the where clause
+func (s *sqlStorage) selectKeys(ctx context.Context, tx *sql.Tx, table *Table, where string, values []interface{}) ([]Record, *StorageError) {
+	rows, e := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s", strings.Join(s.quoteAll(table.Key), ","), s.dialect.quote(table.Name))+where, values...)
+	if err := s.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys, e := scanRecords(rows)
+	return keys, s.interpret(ctx, e)
+}
+
+//an IN condition on the key columns for the given keys, bind parameters are numbered after the given offset
+//composite keys are compared as row values
+func (s *sqlStorage) keyIn(table *Table, keys []Record, offset int) (string, []interface{}) {
+	var rows []string
+	var values []interface{}
+	for _, key := range keys {
+		row := s.placeholders(offset+len(values), len(table.Key))
+		for _, k := range table.Key {
+			values = append(values, key[k])
+		}
+		rows = append(rows, strings.Join(row, ","))
+	}
+	if len(table.Key) == 1 {
+		return fmt.Sprintf(" WHERE %s IN (%s)", s.dialect.quote(table.Key[0]), strings.Join(rows, ",")), values
+	}
+	return fmt.Sprintf(" WHERE (%s) IN ((%s))", strings.Join(s.quoteAll(table.Key), ","), strings.Join(rows, "),(")), values
+}
+
+//the url forms of the keys, values arrive from json and urls as strings or numbers
+func keySet(table *Table, keys []Record) map[string]bool {
+	set := map[string]bool{}
+	for _, key := range keys {
+		set[table.keyPath(key)] = true
+	}
+	return set
+}
+
+//bulk statements are built for the number of records they carry, so they bypass the statement cache
+func (s *sqlStorage) BulkCreate(ctx context.Context, resource Resource, records Records) (*Response, *StorageError) {
+	table, err := s.table(resource)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, &StorageError{Code: 400, Message: "no records given"}
+	}
+	for i, record := range records {
+		if err = table.validate(record); err != nil {
+			return nil, itemError(i, err)
+		}
+	}
+
+	keys := make([]Record, len(records))
+	created := map[string]Record{}
+	shapes, groups := groupByColumns(records)
+	err = s.transaction(ctx, func(tx *sql.Tx) *StorageError {
+		for g, columns := range shapes {
+			for _, run := range chunks(groups[g], len(columns)) {
+				if err := s.insertRun(ctx, tx, table, columns, records, run, keys); err != nil {
+					return err
+				}
+			}
+		}
+
+		//the created rows are read back within the transaction, so defaults and generated values reach the client
+		var known []Record
+		for _, key := range keys {
+			if key != nil {
+				known = append(known, key)
+			}
+		}
+		if len(known) == 0 {
+			return nil
+		}
+		for _, run := range chunks(indexes(len(known)), len(table.Key)) {
+			var runKeys []Record
+			for _, i := range run {
+				runKeys = append(runKeys, known[i])
+			}
+			where, values := s.keyIn(table, runKeys, 0)
+			rows, e := tx.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %s", s.dialect.quote(table.Name))+where, values...)
+			if err := s.interpret(ctx, e); err != nil {
+				return err
+			}
+			read, e := scanRecords(rows)
+			rows.Close()
+			if err := s.interpret(ctx, e); err != nil {
+				return err
+			}
+			for _, record := range read {
+				created[table.keyPath(record)] = record
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := Response{Created: int64(len(records))}
+	for _, key := range keys {
+		if key == nil {
+			//the key of the row could not be learnt, so it can't be reported
+			result.Items = append(result.Items, Item{Status: 201})
+			continue
+		}
+		result.Items = append(result.Items, Item{Status: 201, Id: table.identify(key)})
+		if record, ok := created[table.keyPath(key)]; ok {
+			result.Data = append(result.Data, record)
+		}
+	}
+	return &result, nil
+}
+
+//the indexes 0 to n-1
+func indexes(n int) []int {
+	all := make([]int, n)
+	for i := range all {
+		all[i] = i
+	}
+	return all
+}
+
+//inserts the records at the run's indexes with one statement, recording the keys they were given
+func (s *sqlStorage) insertRun(ctx context.Context, tx *sql.Tx, table *Table, columns []string, records Records, run []int, keys []Record) *StorageError {
+	var rows []string
+	var values []interface{}
+	for _, i := range run {
+		rows = append(rows, "("+strings.Join(s.placeholders(len(values), len(columns)), ",")+")")
+		for _, c := range columns {
+			values = append(values, records[i][c])
+		}
+	}
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s", s.dialect.quote(table.Name), strings.Join(s.quoteAll(columns), ","), strings.Join(rows, ","))
+
+	//the records of a run hold the same columns, so either all of them hold their key or none do
+	if _, err := table.keyOf(records[run[0]]); err == nil || len(table.Key) == 0 {
+		for _, i := range run {
+			keys[i], _ = table.keyOf(records[i])
+		}
+		if _, e := tx.ExecContext(ctx, statement, values...); e != nil || len(table.Key) == 0 {
+			return s.interpret(ctx, e)
+		}
+		return s.interpret(ctx, s.dialect.advanceKey(ctx, tx, table))
+	}
+
+	//the run's columns are returned along with the key, as the order of returned rows is not promised
+	returned := append([]string{}, table.Key...)
+	for _, c := range columns {
+		if !contains(returned, c) {
+			returned = append(returned, c)
+		}
+	}
+	if returning := s.dialect.returning(strings.Join(s.quoteAll(returned), ",")); returning != "" {
+		rows, e := tx.QueryContext(ctx, statement+returning, values...)
+		if err := s.interpret(ctx, e); err != nil {
+			return err
+		}
+		defer rows.Close()
+		inserted, e := scanRecords(rows)
+		if err := s.interpret(ctx, e); err != nil {
+			return err
+		}
+		matchInserted(table, columns, records, run, keys, inserted)
+		return nil
+	}
+
+	if len(table.Key) > 1 {
+		//only a single generated column can be learnt from the driver
+		_, e := tx.ExecContext(ctx, statement, values...)
+		return s.interpret(ctx, e)
+	}
+	//without RETURNING the rows are inserted one at a time, the ids of a multi row insert need not be consecutive
+	statement = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", s.dialect.quote(table.Name), strings.Join(s.quoteAll(columns), ","), strings.Join(s.placeholders(0, len(columns)), ","))
+	for n, i := range run {
+		r, e := tx.ExecContext(ctx, statement, values[n*len(columns):(n+1)*len(columns)]...)
+		if err := s.interpret(ctx, e); err != nil {
+			return err
+		}
+		id, e := r.LastInsertId()
+		if err := s.interpret(ctx, e); err != nil {
+			return err
+		}
+		keys[i] = Record{table.Key[0]: id}
+	}
+	return nil
+}
+
+//gives each record of the run the key of the first inserted row holding its values
+//a record no row can be told apart for is left without a key
+func matchInserted(table *Table, columns []string, records Records, run []int, keys []Record, inserted Records) {
+	taken := make([]bool, len(inserted))
+	for _, i := range run {
+		for n, row := range inserted {
+			if taken[n] || !holds(row, records[i], columns) {
+				continue
+			}
+			taken[n] = true
+			keys[i] = Record{}
+			for _, k := range table.Key {
+				keys[i][k] = row[k]
+			}
+			break
+		}
+	}
+}
+
+//whether the row holds the record's values for the columns
+func holds(row Record, record Record, columns []string) bool {
+	for _, c := range columns {
+		if !sameValue(row[c], record[c]) {
+			return false
+		}
+	}
+	return true
+}
+
+//updates the records with one statement per run, each column is set by a CASE on the key
+//records whose key matches no row meeting the where filters are reported as 404 items
+func (s *sqlStorage) BulkUpdate(ctx context.Context, resource Resource, records Records, where ...Filter) (*Response, *StorageError) {
+	table, err := s.table(resource)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, &StorageError{Code: 400, Message: "no records given"}
+	}
+	keys := make([]Record, len(records))
+	seen := map[string]bool{}
+	for i, record := range records {
+		if keys[i], err = table.keyOf(record); err != nil {
+			return nil, itemError(i, err)
+		}
+		path := table.keyPath(record)
+		if seen[path] {
+			return nil, itemError(i, &StorageError{Code: 400, Message: fmt.Sprintf("key '%s' is given more than once", path)})
+		}
+		seen[path] = true
+		if err = table.validate(record); err != nil {
+			return nil, itemError(i, err)
+		}
+	}
+
+	var existing map[string]bool
+	err = s.transaction(ctx, func(tx *sql.Tx) *StorageError {
+		var found []Record
+		for _, run := range chunks(indexes(len(records)), len(table.Key)) {
+			var runKeys []Record
+			for _, i := range run {
+				runKeys = append(runKeys, keys[i])
+			}
+			clause, values := s.keyIn(table, runKeys, 0)
+			clause, filterValues, err := s.narrow(table, clause, where, len(values))
+			if err != nil {
+				return err
+			}
+			runFound, err := s.selectKeys(ctx, tx, table, clause, append(values, filterValues...))
+			if err != nil {
+				return err
+			}
+			found = append(found, runFound...)
+		}
+		existing = keySet(table, found)
+
+		//each record binds its key twice and a value per column at most
+		for _, run := range chunks(indexes(len(records)), 2*len(table.Key)*len(table.Columns)) {
+			if err := s.updateRun(ctx, tx, table, records, run, where); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := Response{}
+	for _, record := range records {
+		if existing[table.keyPath(record)] {
+			result.Updated++
+			result.Items = append(result.Items, Item{Status: 200, Id: table.identify(record)})
+		} else {
+			result.Items = append(result.Items, Item{Status: 404, Id: table.identify(record), Message: "record not found"})
+		}
+	}
+	return &result, nil
+}
+
+//updates the records at the run's indexes whose rows meet the where filters with one statement
+func (s *sqlStorage) updateRun(ctx context.Context, tx *sql.Tx, table *Table, records Records, run []int, where []Filter) *StorageError {
+	var sets []string
+	var values []interface{}
+	for _, c := range table.Columns {
+		if table.isKey(c) {
+			continue
+		}
+		var whens []string
+		for _, i := range run {
+			v, ok := records[i][c]
+			if !ok {
+				continue
+			}
+			var conditions []string
+			for _, k := range table.Key {
+				values = append(values, records[i][k])
+				conditions = append(conditions, s.dialect.quote(k)+"="+s.dialect.placeholder(len(values)))
+			}
+			values = append(values, v)
+			whens = append(whens, fmt.Sprintf("WHEN %s THEN %s", strings.Join(conditions, " AND "), s.dialect.placeholder(len(values))))
+		}
+		if len(whens) > 0 {
+			column := s.dialect.quote(c)
+			sets = append(sets, fmt.Sprintf("%s = CASE %s ELSE %s END", column, strings.Join(whens, " "), column))
+		}
+	}
+	if len(sets) == 0 {
+		//the records only hold keys, there is nothing to set
+		return nil
+	}
+
+	var keys []Record
+	for _, i := range run {
+		keys = append(keys, records[i])
+	}
+	clause, keyValues := s.keyIn(table, keys, len(values))
+	values = append(values, keyValues...)
+	clause, filterValues, err := s.narrow(table, clause, where, len(values))
+	if err != nil {
+		return err
+	}
+	statement := fmt.Sprintf("UPDATE %s SET %s", s.dialect.quote(table.Name), strings.Join(sets, ", ")) + clause
+	_, e := tx.ExecContext(ctx, statement, append(values, filterValues...)...)
+	return s.interpret(ctx, e)
+}
+
+//deletes every row meeting the query's filters with one statement, a filter is required so a bare request can't empty the table
+func (s *sqlStorage) BulkDelete(ctx context.Context, resource Resource, query *Query) (*Response, *StorageError) {
+	table, err := s.table(resource)
+	if err != nil {
+		return nil, err
+	}
+	if len(query.Filters) == 0 {
+		return nil, &StorageError{Code: 400, Message: "a bulk delete requires a filter"}
+	}
+	where, values, err := s.where(table, &Query{Filters: query.Filters}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []Record
+	var deleted int64
+	err = s.transaction(ctx, func(tx *sql.Tx) *StorageError {
+		var err *StorageError
+		if len(table.Key) > 0 {
+			if keys, err = s.selectKeys(ctx, tx, table, where, values); err != nil {
+				return err
+			}
+		}
+		r, e := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s", s.dialect.quote(table.Name))+where, values...)
+		if err = s.interpret(ctx, e); err != nil {
+			return err
+		}
+		deleted, e = r.RowsAffected()
+		return s.interpret(ctx, e)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := Response{Deleted: deleted}
+	for _, key := range keys {
+		result.Items = append(result.Items, Item{Status: 200, Id: table.identify(key)})
+	}
+	return &result, nil
+}
@@ -1,10 +1,12 @@
 package pkg
 
 import (
+	"crypto"
 	"os"
 	"strconv"
 	"log"
 	"strings"
+	"time"
 )
 
 type Configuration struct {
@@ -12,10 +14,36 @@ type Configuration struct {
 	ConnectionString string //our storage connection string
 	LimitDefault     int    //our default upper limit\
 
+	//our connection pool
+	MaxOpenConnections    int           //the most connections open to the database at once, 0 is unlimited
+	MaxIdleConnections    int           //the most idle connections kept open for reuse
+	ConnectionMaxLifetime time.Duration //how long a connection is reused before it is replaced, 0 is forever
+	StatementCacheSize    int           //the most prepared statements kept for reuse, 0 disables the cache
+	MetricsAddress        string        //where to serve our counters as expvar json, empty disables it
+	QueryTimeout          time.Duration //how long a request may spend in the database before it fails with a 504, 0 is forever
+
+	//our resources
+	PrimaryKeys map[string][]string //the primary key columns of tables by name, overriding what the schema says
+
+	//our authentication
+	AuthRequired   bool                        //whether requests without credentials are refused with a 401
+	JWTSecret      string                      //the shared secret HS256 tokens are signed with, empty refuses them
+	JWTKeys        map[string]crypto.PublicKey //the keys RS256 and ES256 tokens are verified against by key id, read from a JWKS file
+	JWTIssuer      string                      //the iss claim tokens must hold, empty accepts any
+	JWTAudience    string                      //the aud claim tokens must hold, empty accepts any
+	APIKeyTable    string                      //the table api keys are looked up in, empty refuses them
+	APIKeyCacheTTL time.Duration               //how long a verified api key is trusted before it is looked up again, 0 looks it up every time
+
+	//our row level security
+	OwnerColumns map[string]string //the column holding the owner of each row, by table name, callers only see and change rows they own
+	AdminRole    string            //the role whose callers see every row regardless of owner
+
 	//our permissions
+	Policy            *Policy //the roles allowed each method on each resource, it replaces the permissions below when set
 	GetPermissions    map[string]string
 	PutPermissions    map[string]string
 	PostPermissions   map[string]string
+	PatchPermissions  map[string]string
 	DeletePermissions map[string]string
 }
 
@@ -27,11 +55,50 @@ func envOrDefault(env string, def string) string {
 	return def
 }
 
+//parses resource:allow;resource:deny into the permission of each resource, global is the fallback for the rest
 func parsePermissionConf(pStr string) map[string]string {
 	conf := make(map[string] string)
 	for _, p := range strings.Split(pStr, ";"){
+		if strings.TrimSpace(p) == "" {
+			continue
+		}
 		rv := strings.Split(p, ":")
-		conf[rv[0]] = rv[1]
+		if len(rv) != 2 {
+			log.Fatal("configuration error: invalid permission value")
+		}
+		permission := strings.TrimSpace(rv[1])
+		if permission != "allow" && permission != "deny" {
+			log.Fatal("configuration error: permissions must be allow or deny")
+		}
+		conf[strings.TrimSpace(rv[0])] = permission
+	}
+	return conf
+}
+
+//parses table:column;table:column into the owner column of each table
+func parseOwnerConf(oStr string) map[string]string {
+	conf := make(map[string]string)
+	for table, columns := range parsePrimaryKeyConf(oStr) {
+		if len(columns) != 1 {
+			log.Fatal("configuration error: invalid owner column value")
+		}
+		conf[table] = columns[0]
+	}
+	return conf
+}
+
+//parses table:column,column;table:column into the key columns of each table
+func parsePrimaryKeyConf(kStr string) map[string][]string {
+	conf := make(map[string][]string)
+	for _, k := range strings.Split(kStr, ";") {
+		tc := strings.SplitN(k, ":", 2)
+		if len(tc) != 2 {
+			if strings.TrimSpace(k) != "" {
+				log.Fatal("configuration error: invalid primary key value")
+			}
+			continue
+		}
+		conf[strings.TrimSpace(tc[0])] = parseFields(tc[1])
 	}
 	return conf
 }
@@ -46,13 +113,78 @@ func Config() *Configuration {
 
 		limit, err := strconv.Atoi(envOrDefault("VEIL_LIMIT_DEFAULT", "30"))
 		if err != nil {
-			log.Fatal("cofiguration error: invalid limit value")
+			log.Fatal("configuration error: invalid limit value")
 		}
 		config.LimitDefault = limit
 
+		maxOpen, err := strconv.Atoi(envOrDefault("VEIL_DB_MAX_OPEN", "10"))
+		if err != nil {
+			log.Fatal("configuration error: invalid max open connections value")
+		}
+		config.MaxOpenConnections = maxOpen
+
+		maxIdle, err := strconv.Atoi(envOrDefault("VEIL_DB_MAX_IDLE", "5"))
+		if err != nil {
+			log.Fatal("configuration error: invalid max idle connections value")
+		}
+		config.MaxIdleConnections = maxIdle
+
+		lifetime, err := time.ParseDuration(envOrDefault("VEIL_DB_CONN_MAX_LIFETIME", "5m"))
+		if err != nil {
+			log.Fatal("configuration error: invalid connection max lifetime value")
+		}
+		config.ConnectionMaxLifetime = lifetime
+
+		cacheSize, err := strconv.Atoi(envOrDefault("VEIL_STMT_CACHE_SIZE", "100"))
+		if err != nil {
+			log.Fatal("configuration error: invalid statement cache size value")
+		}
+		config.StatementCacheSize = cacheSize
+
+		timeout, err := time.ParseDuration(envOrDefault("VEIL_QUERY_TIMEOUT", "30s"))
+		if err != nil {
+			log.Fatal("configuration error: invalid query timeout value")
+		}
+		config.QueryTimeout = timeout
+
+		config.MetricsAddress = envOrDefault("VEIL_METRICS_ADDR", "")
+		config.PrimaryKeys = parsePrimaryKeyConf(envOrDefault("VEIL_PRIMARY_KEYS", ""))
+
+		required, err := strconv.ParseBool(envOrDefault("VEIL_AUTH_REQUIRED", "false"))
+		if err != nil {
+			log.Fatal("configuration error: invalid auth required value")
+		}
+		config.AuthRequired = required
+		config.JWTSecret = envOrDefault("VEIL_JWT_SECRET", "")
+		if path := envOrDefault("VEIL_JWKS_FILE", ""); path != "" {
+			config.JWTKeys, err = loadJWKS(path)
+			if err != nil {
+				log.Fatal("configuration error: invalid JWKS file: ", err)
+			}
+		}
+		config.JWTIssuer = envOrDefault("VEIL_JWT_ISSUER", "")
+		config.JWTAudience = envOrDefault("VEIL_JWT_AUDIENCE", "")
+		config.APIKeyTable = envOrDefault("VEIL_API_KEY_TABLE", "")
+
+		keyTTL, err := time.ParseDuration(envOrDefault("VEIL_API_KEY_CACHE_TTL", "1m"))
+		if err != nil {
+			log.Fatal("configuration error: invalid api key cache ttl value")
+		}
+		config.APIKeyCacheTTL = keyTTL
+
+		config.OwnerColumns = parseOwnerConf(envOrDefault("VEIL_OWNER_COLUMNS", ""))
+		config.AdminRole = envOrDefault("VEIL_ADMIN_ROLE", "admin")
+
+		if path := envOrDefault("VEIL_POLICY_FILE", ""); path != "" {
+			config.Policy, err = LoadPolicy(path)
+			if err != nil {
+				log.Fatal("configuration error: invalid policy file: ", err)
+			}
+		}
 		config.GetPermissions = parsePermissionConf(envOrDefault("VEL_GET_PERMISSIONS", "global:allow"))
 		config.PutPermissions = parsePermissionConf(envOrDefault("VEL_PUT_PERMISSIONS", "global:deny"))
 		config.PostPermissions = parsePermissionConf(envOrDefault("VEL_POST_PERMISSIONS", "global:deny"))
+		config.PatchPermissions = parsePermissionConf(envOrDefault("VEL_PATCH_PERMISSIONS", "global:deny"))
 		config.DeletePermissions = parsePermissionConf(envOrDefault("VEL_DELETE_PERMISSIONS", "global:deny"))
 	}
 
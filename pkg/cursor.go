@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+//encodes the sort key values of the last record read as an opaque cursor
+func encodeCursor(values []interface{}) string {
+	b, _ := json.Marshal(values)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//decodes a cursor made by encodeCursor, numbers are kept as json.Number so large ids survive
+func decodeCursor(cursor string) ([]interface{}, error) {
+	b, e := base64.RawURLEncoding.DecodeString(cursor)
+	if e != nil {
+		return nil, e
+	}
+	var values []interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if e = d.Decode(&values); e != nil {
+		return nil, e
+	}
+	return values, nil
+}
+
+//whether the sort keys include the column
+func sortsBy(keys []Sort, column string) bool {
+	for _, k := range keys {
+		if k.Column == column {
+			return true
+		}
+	}
+	return false
+}
+
+//prepares a query for keyset pagination after the given cursor, an empty cursor reads the first page
+//records are ordered by their primary key last so every record has a distinct position, and the sort columns
+//are always read so the next cursor can be built, the returned columns were added for that alone
+//without a primary key records tied on the sort have no position to page from, so it is refused
+func cursorQuery(query *Query, key []string, cursor string) ([]string, error) {
+	if len(key) == 0 {
+		return nil, errors.New("'after' needs a resource with a primary key")
+	}
+	for _, k := range key {
+		if !sortsBy(query.Sort, k) {
+			query.Sort = append(query.Sort, Sort{Column: k})
+		}
+	}
+	query.Offset = 0
+
+	if cursor != "" {
+		values, e := decodeCursor(cursor)
+		if e != nil || len(values) != len(query.Sort) {
+			//a cursor made for another sort, or none at all, would silently read the wrong page
+			return nil, errors.New("improper value for 'after'")
+		}
+		query.After = values
+	}
+
+	var hidden []string
+	if len(query.Fields) > 0 {
+		for _, k := range query.Sort {
+			if !contains(query.Fields, k.Column) {
+				query.Fields = append(query.Fields, k.Column)
+				hidden = append(hidden, k.Column)
+			}
+		}
+	}
+	return hidden, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package pkg
 
 import (
+	"context"
 	"net/http"
 	"strings"
 	"encoding/json"
@@ -8,6 +9,7 @@ import (
 	"strconv"
 	"net/url"
 	"fmt"
+	"sort"
 )
 
 func parsePath(path string) []string {
@@ -15,6 +17,44 @@ func parsePath(path string) []string {
 	return segments[1:]
 }
 
+//the segments of the request's path, /resource or /resource/key, nil for any other path as it addresses no resource
+//the escaped path is split, so key values may hold escaped commas and slashes, the key segment is left escaped
+func resourceSegments(r *http.Request) []string {
+	segments := parsePath(r.URL.EscapedPath())
+	if len(segments) == 0 || len(segments) > 2 {
+		return nil
+	}
+	name, e := url.PathUnescape(segments[0])
+	if e != nil {
+		return nil
+	}
+	segments[0] = name
+	return segments
+}
+
+//the name of the resource the request addresses, empty when it addresses none
+//filters judge requests by it, so it must be the resource the handlers act on
+func requestedResource(r *http.Request) string {
+	if segments := resourceSegments(r); segments != nil {
+		return segments[0]
+	}
+	return ""
+}
+
+//the key of the record addressed by the request's /resource/key path
+func parseRecordKey(r *http.Request, storage Storage, resource Resource) (*Table, Record, *StorageError) {
+	table, err := storage.Describe(resource)
+	if err != nil {
+		return nil, nil, err
+	}
+	segments := resourceSegments(r)
+	key, err := table.parseKey(segments[len(segments)-1])
+	if err != nil {
+		return nil, nil, err
+	}
+	return table, key, nil
+}
+
 //our response struct is always used to return data to the client
 //this keeps our api nice and consistent
 type Response struct {
@@ -25,6 +65,22 @@ type Response struct {
 	Updated int64   `json:"updated"` //if the db updates data it will be reflected here
 	Deleted int64   `json:"deleted"` //if the db deletes data it will be reflected here
 	Links   []Link  `json:"links"`
+	Meta    *Meta   `json:"meta,omitempty"` //pagination details, only present when asked for with ?count=true
+	Items   []Item  `json:"items,omitempty"` //the outcome for each record of a bulk request, in request order
+}
+
+//the outcome of one record of a bulk request
+type Item struct {
+	Status  int         `json:"status"`            //follows http status code conventions
+	Id      interface{} `json:"id"`                //the id of the record
+	Message string      `json:"message,omitempty"` //why the record was not written
+}
+
+//describes where a page sits in the whole listing
+type Meta struct {
+	Total  int64 `json:"total"`  //how many records match the filters
+	Offset int   `json:"offset"` //how many matching records precede this page
+	Limit  int   `json:"limit"`  //the most records on a page
 }
 
 //Write our response to the client
@@ -69,23 +125,41 @@ func intParamOrDefault(values url.Values, param string, def int) (int, error) {
 //GET /resource -- returns records up to default limit at the default offset
 //GET /resource?limit=x -- returns records up to given limit at the default offset
 //GET /resource?offset=x&limit=y -- return records up to limit from given offset
-//GET /resource/id -- gets the resource at the given id
+//GET /resource?sort=-x,y -- returns records ordered by x descending then y ascending
+//GET /resource?fields=x,y -- returns only columns x and y of each record
+//GET /resource?after=&limit=x -- returns the first x records, the next link carries a cursor instead of an offset
+//GET /resource?after=cursor&limit=x -- returns x records following the cursor
+//GET /resource?count=true -- adds the number of matching records and a last link
+//GET /resource/key -- gets the resource at the given primary key, composite keys are given as a,b
+//GET /resource/key?fields=x,y -- gets columns x and y of the resource at the given key
 func HandleGet(w http.ResponseWriter, r *http.Request, storage Storage) {
 
-	segments := parsePath(r.URL.Path)
-
-	var record Record
-	var resource Resource
-
-	if len(segments) == 2 {
-		record = Record{"id": segments[1]}
-		resource = Resource{segments[len(segments)-2]}
-	} else {
+	segments := resourceSegments(r)
+	if segments == nil {
+		MessageResponse(w, 404, "resource not found")
+		return
+	}
+	if len(segments) != 2 {
 		HandleGetMulti(w, r, storage)
 		return
 	}
 
-	result, err := storage.Read(resource, &record, 0, 1)
+	resource := Resource{segments[0]}
+	owner, err := bindOwner(r, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	_, record, err := parseRecordKey(r, storage, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	owner.own(record)
+
+	query := MatchQuery(record, 0, 1)
+	query.Fields = parseFields(r.URL.Query().Get("fields"))
+	result, err := storage.Read(r.Context(), resource, query)
 	if err != nil {
 		MessageResponse(w, err.Code, err.Message)
 	} else {
@@ -99,11 +173,86 @@ func HandleGet(w http.ResponseWriter, r *http.Request, storage Storage) {
 	}
 }
 
+//parses a query parameter into a filter
+//?column=value -- column equals value
+//?column[op]=value -- column compared with value by op, see the operator constants
+//?column[in]=a,b -- column is one of the comma separated values
+//?column[null]=true -- column is null, false for not null
+func parseFilter(key string, value string) (*Filter, error) {
+	f := Filter{Column: key, Operator: Eq, Value: value}
+	if open := strings.Index(key, "["); open != -1 && strings.HasSuffix(key, "]") {
+		f.Column = key[:open]
+		f.Operator = key[open+1 : len(key)-1]
+	}
+
+	switch f.Operator {
+	case Eq, Ne, Gt, Gte, Lt, Lte, Like:
+	case In:
+		var list []interface{}
+		for _, v := range strings.Split(value, ",") {
+			list = append(list, v)
+		}
+		f.Value = list
+	case Null:
+		isNull, e := strconv.ParseBool(value)
+		if e != nil {
+			return nil, fmt.Errorf("improper value for '%s'", key)
+		}
+		f.Value = isNull
+	default:
+		return nil, fmt.Errorf("unknown operator '%s'", f.Operator)
+	}
+	return &f, nil
+}
+
+//parses a comma separated list of columns to return
+//?fields=id,name -- returns only the id and name of each record
+func parseFields(value string) []string {
+	var fields []string
+	for _, f := range strings.Split(value, ",") {
+		if f != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+//parses a comma separated list of columns into sort keys, a leading - sorts descending
+//?sort=-created_at,name -- newest first, then by name
+func parseSort(value string) []Sort {
+	var keys []Sort
+	for _, column := range strings.Split(value, ",") {
+		if column == "" {
+			continue
+		}
+		if strings.HasPrefix(column, "-") {
+			keys = append(keys, Sort{Column: column[1:], Descending: true})
+		} else {
+			keys = append(keys, Sort{Column: column})
+		}
+	}
+	return keys
+}
+
+//a link to this listing with the given parameters replaced or removed
+func listLink(r *http.Request, rel string, replace map[string]string, remove ...string) Link {
+	params := r.URL.Query()
+	for k, v := range replace {
+		params.Set(k, v)
+	}
+	for _, k := range remove {
+		params.Del(k)
+	}
+	return Link{Rel: rel, Href: fmt.Sprintf("http://%s%s?%s", r.Host, r.URL.Path, params.Encode()), Method: "GET"}
+}
+
 func HandleGetMulti(w http.ResponseWriter, r *http.Request, storage Storage) {
-	segments := parsePath(r.URL.Path)
-	var record Record
-	var resource Resource
-	resource = Resource{segments[len(segments)-1]}
+	segments := resourceSegments(r)
+	if segments == nil {
+		MessageResponse(w, 404, "resource not found")
+		return
+	}
+	resource := Resource{segments[0]}
 	params := r.URL.Query()
 
 	offset, e := intParamOrDefault(params, "offset", 0)
@@ -126,50 +275,155 @@ func HandleGetMulti(w http.ResponseWriter, r *http.Request, storage Storage) {
 		offset = 0
 	}
 
-	for key, value := range r.URL.Query(){
-		if key != "limit" && key != "offset" {
-			if record == nil{
-				record = Record{}
-			}
-			record[key] = value[0]
-		}
+	query := Query{Offset: offset, Limit: limit, Sort: parseSort(params.Get("sort")), Fields: parseFields(params.Get("fields"))}
+	query.Filters, e = parseFilters(params)
+	if e != nil {
+		MessageResponse(w, 400, e.Error())
+		return
+	}
+	owner, err := bindOwner(r, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	if owner != nil {
+		query.Filters = append(query.Filters, owner.filter())
 	}
 
+	count := params.Get("count")
+	if count != "" {
+		query.Count, e = strconv.ParseBool(count)
+		if e != nil {
+			MessageResponse(w, 400, "improper value for 'count'")
+			return
+		}
+	}
 
+	_, cursorMode := params["after"]
+	var hidden []string
+	if cursorMode {
+		table, err := storage.Describe(resource)
+		if err != nil {
+			MessageResponse(w, err.Code, err.Message)
+			return
+		}
+		hidden, e = cursorQuery(&query, table.Key, params.Get("after"))
+		if e != nil {
+			MessageResponse(w, 400, e.Error())
+			return
+		}
+	}
 
-	result, err := storage.Read(resource, &record, offset, limit)
+	result, err := storage.Read(r.Context(), resource, &query)
 	if err != nil {
 		MessageResponse(w, err.Code, err.Message)
 	} else {
 
 		result.Links = append(result.Links, Link{"self", "http://" + r.Host + r.RequestURI, "GET"})
 
+		if cursorMode {
+			result.Links = append(result.Links, listLink(r, "first", map[string]string{"after": "", "limit": strconv.Itoa(limit)}, "offset"))
+			if len(result.Data) == limit {
+				cursor := encodeCursor(sortValues(result.Data[len(result.Data)-1], query.Sort))
+				result.Links = append(result.Links, listLink(r, "next", map[string]string{"after": cursor, "limit": strconv.Itoa(limit)}, "offset"))
+			}
+			for _, record := range result.Data {
+				for _, h := range hidden {
+					delete(record, h)
+				}
+			}
+			if result.Meta != nil {
+				result.Meta.Limit = limit
+			}
+			result.Write(w, 200)
+			return
+		}
+
+		result.Links = append(result.Links, listLink(r, "first", map[string]string{"offset": "0", "limit": strconv.Itoa(limit)}))
+
 		//todo this could be smarter
 		if offset > 0 {
 			previousPageOffset := offset - limit
 			if previousPageOffset < 0 {
 				previousPageOffset = 0
 			}
-			link := Link{Rel: "prev", Method: "GET"}
-			link.Href = fmt.Sprintf("http://%s%s?offset=%d&limit=%d", r.Host, r.URL.Path, previousPageOffset, limit)
-			result.Links = append(result.Links, link)
+			result.Links = append(result.Links, listLink(r, "prev", map[string]string{"offset": strconv.Itoa(previousPageOffset), "limit": strconv.Itoa(limit)}))
 		}
 
 		//todo this could be smarter
 		if len(result.Data) == limit {
 			nextPageOffset := offset + limit
-			link := Link{Rel: "next", Method: "GET"}
-			link.Href = fmt.Sprintf("http://%s%s?offset=%d&limit=%d", r.Host, r.URL.Path, nextPageOffset, limit)
-			result.Links = append(result.Links, link)
+			result.Links = append(result.Links, listLink(r, "next", map[string]string{"offset": strconv.Itoa(nextPageOffset), "limit": strconv.Itoa(limit)}))
+		}
+
+		if result.Meta != nil {
+			result.Meta.Offset = offset
+			result.Meta.Limit = limit
+			lastPageOffset := int64(0)
+			if result.Meta.Total > 0 {
+				lastPageOffset = (result.Meta.Total - 1) / int64(limit) * int64(limit)
+			}
+			result.Links = append(result.Links, listLink(r, "last", map[string]string{"offset": strconv.FormatInt(lastPageOffset, 10), "limit": strconv.Itoa(limit)}))
 		}
 		result.Write(w, 200)
 	}
 }
 
+//parses every query parameter that isn't one of our reserved listing parameters into a filter
+func parseFilters(params url.Values) ([]Filter, error) {
+	var filters []Filter
+	for _, key := range sortedParams(params) {
+		if key != "limit" && key != "offset" && key != "sort" && key != "fields" && key != "after" && key != "count" {
+			f, e := parseFilter(key, params.Get(key))
+			if e != nil {
+				return nil, e
+			}
+			filters = append(filters, *f)
+		}
+	}
+	return filters, nil
+}
+
+//the names of the query parameters in a stable order
+func sortedParams(params url.Values) []string {
+	var keys []string
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//handle a put call
+//PUT /resource -- creates a record, the created record is returned and its url given in the Location header
+//PUT /resource with an array -- creates every record in one transaction
+//PUT /resource/key -- creates the record with the key or replaces the columns given on the existing one, 201 when created and 200 when replaced
 func HandlePut(w http.ResponseWriter, r *http.Request, storage Storage) {
-	segments := parsePath(r.URL.Path)
+	segments := resourceSegments(r)
+	if segments == nil {
+		MessageResponse(w, 404, "resource not found")
+		return
+	}
 	b, _ := ioutil.ReadAll(r.Body)
 
+	//the owner is bound to the resource written, whatever the path
+	resource := Resource{segments[0]}
+	owner, err := bindOwner(r, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+
+	if len(segments) == 1 && isArray(b) {
+		handleBulk(w, b, func(records Records) (*Response, *StorageError) {
+			for _, record := range records {
+				owner.own(record)
+			}
+			return storage.BulkCreate(r.Context(), resource, records)
+		})
+		return
+	}
+
 	record := Record{}
 	e := json.Unmarshal(b, &record)
 	if e != nil {
@@ -177,16 +431,47 @@ func HandlePut(w http.ResponseWriter, r *http.Request, storage Storage) {
 		return
 	}
 
-	resource := Resource{segments[len(segments)-1]}
-	result, err := storage.Create(resource, record)
+	owner.own(record)
+
+	var result *Response
+	var table *Table
+	if len(segments) == 2 {
+		//the key in the path names the record, it is created or replaced
+		var key Record
+		if table, key, err = parseRecordKey(r, storage, resource); err == nil {
+			for k, v := range key {
+				record[k] = v
+			}
+			//another's row can't be replaced, so it is as good as missing
+			result, err = storage.Upsert(r.Context(), resource, record, owner.where()...)
+		}
+	} else {
+		if table, err = storage.Describe(resource); err == nil {
+			result, err = storage.Create(r.Context(), resource, record)
+		}
+	}
 
 	if err != nil {
 		MessageResponse(w, err.Code, err.Message)
 	} else {
+		//the stored record knows its key, the one in the path is only a string
+		known := record
+		if len(result.Data) > 0 {
+			known = result.Data[0]
+		}
+		_, keyErr := table.keyOf(known)
+		path := fmt.Sprintf("/%s/%s", resource.Identifier, table.keyPath(known))
+		if keyErr == nil {
+			result.Links = append(result.Links, Link{Rel: "self", Href: "http://" + r.Host + path, Method: "GET"})
+		}
+
 		status := 200
 		if result.Created != 0 {
 			status = 201
 			result.Message = "success"
+			if keyErr == nil {
+				w.Header().Set("Location", path)
+			}
 		}
 		result.Write(w, status)
 
@@ -194,9 +479,31 @@ func HandlePut(w http.ResponseWriter, r *http.Request, storage Storage) {
 
 }
 
+//handle a post call
+//POST /resource/key -- updates the columns given on the record
+//POST /resource with an array of records holding their keys -- updates every record in one transaction
 func HandlePost(w http.ResponseWriter, r *http.Request, storage Storage) {
-	segments := parsePath(r.URL.Path)
+	segments := resourceSegments(r)
+	if segments == nil {
+		MessageResponse(w, 404, "resource not found")
+		return
+	}
 	b, _ := ioutil.ReadAll(r.Body)
+	resource := Resource{segments[0]}
+	owner, err := bindOwner(r, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	if len(segments) == 1 {
+		handleBulk(w, b, func(records Records) (*Response, *StorageError) {
+			for _, record := range records {
+				owner.own(record)
+			}
+			return storage.BulkUpdate(r.Context(), resource, records, owner.where()...)
+		})
+		return
+	}
 	record := Record{}
 	e := json.Unmarshal(b, &record)
 	if e != nil {
@@ -204,22 +511,143 @@ func HandlePost(w http.ResponseWriter, r *http.Request, storage Storage) {
 		return
 	}
 
-	record["id"] = segments[len(segments)-1]
-	resource := Resource{segments[len(segments)-2]}
-	result, err := storage.Update(resource, record)
+	_, key, err := parseRecordKey(r, storage, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	for k, v := range key {
+		record[k] = v
+	}
+	owner.own(record)
+	result, err := storage.Update(r.Context(), resource, record, owner.where()...)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+	} else if owner != nil && result.Updated == 0 {
+		//another's row is as good as missing
+		MessageResponse(w, 404, "record not found")
+	} else {
+		result.Write(w, 200)
+	}
+}
+
+//handle a patch call, the record is read first so unknown keys are a 404 and json patches can be evaluated
+//PATCH /resource/key with Content-Type application/merge-patch+json -- sets the given columns, null sets a column to NULL
+//PATCH /resource/key with Content-Type application/json-patch+json -- applies the operations to the record
+func HandlePatch(w http.ResponseWriter, r *http.Request, storage Storage) {
+	segments := resourceSegments(r)
+	if segments == nil {
+		MessageResponse(w, 404, "resource not found")
+		return
+	}
+	if len(segments) != 2 {
+		MessageResponse(w, 400, "PATCH requires a record key")
+		return
+	}
+	resource := Resource{segments[0]}
+	b, _ := ioutil.ReadAll(r.Body)
+
+	contentType := strings.TrimSpace(strings.Split(r.Header.Get("Content-Type"), ";")[0])
+	if contentType != MergePatchContentType && contentType != JSONPatchContentType {
+		MessageResponse(w, 415, fmt.Sprintf("PATCH expects %s or %s", MergePatchContentType, JSONPatchContentType))
+		return
+	}
+
+	owner, err := bindOwner(r, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	table, key, err := parseRecordKey(r, storage, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+
+	//another's row is as good as missing
+	match := copyRecord(key)
+	owner.own(match)
+	current, err := storage.Read(r.Context(), resource, MatchQuery(match, 0, 1))
 	if err != nil {
 		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	if len(current.Data) == 0 {
+		MessageResponse(w, 404, "record not found")
+		return
+	}
+
+	var changes Record
+	var patchErr *patchError
+	if contentType == MergePatchContentType {
+		changes, patchErr = parseMergePatch(b, table.Key)
+	} else {
+		changes, patchErr = applyJSONPatch(current.Data[0], b, table.Key)
+	}
+	if patchErr != nil {
+		MessageResponse(w, patchErr.Code, patchErr.Message)
+		return
+	}
+
+	if len(changes) == 0 {
+		(&Response{}).Write(w, 200)
+		return
+	}
+
+	for k, v := range key {
+		changes[k] = v
+	}
+	owner.own(changes)
+	result, err := storage.Update(r.Context(), resource, changes, owner.where()...)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+	} else if owner != nil && result.Updated == 0 {
+		//the row changed hands since it was read
+		MessageResponse(w, 404, "record not found")
 	} else {
 		result.Write(w, 200)
 	}
 }
 
+//handle a delete call
+//DELETE /resource/key -- deletes the record
+//DELETE /resource?id[in]=x,y -- deletes every record meeting the filters in one transaction, see parseFilter
 func HandleDelete(w http.ResponseWriter, r *http.Request, storage Storage) {
-	segments := parsePath(r.URL.Path)
-	record := Record{}
-	record["id"] = segments[len(segments)-1]
-	resource := Resource{segments[len(segments)-2]}
-	result, err := storage.Delete(resource, record)
+	segments := resourceSegments(r)
+	if segments == nil {
+		MessageResponse(w, 404, "resource not found")
+		return
+	}
+	resource := Resource{segments[0]}
+	owner, err := bindOwner(r, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	if len(segments) == 1 {
+		filters, e := parseFilters(r.URL.Query())
+		if e != nil {
+			MessageResponse(w, 400, e.Error())
+			return
+		}
+		//the caller's filters must still narrow the delete, it is never every row they own
+		if owner != nil && len(filters) > 0 {
+			filters = append(filters, owner.filter())
+		}
+		result, err := storage.BulkDelete(r.Context(), resource, &Query{Filters: filters})
+		if err != nil {
+			MessageResponse(w, err.Code, err.Message)
+		} else {
+			result.Write(w, 200)
+		}
+		return
+	}
+	_, record, err := parseRecordKey(r, storage, resource)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	result, err := storage.Delete(r.Context(), resource, record, owner.where()...)
 	if err != nil {
 		MessageResponse(w, err.Code, err.Message)
 	} else {
@@ -233,15 +661,61 @@ func HandleDelete(w http.ResponseWriter, r *http.Request, storage Storage) {
 
 
 
+//whether the payload is a json array rather than a single record
+func isArray(b []byte) bool {
+	trimmed := strings.TrimSpace(string(b))
+	return strings.HasPrefix(trimmed, "[")
+}
+
+//parses an array of records and writes the outcome of the bulk operation on them
+func handleBulk(w http.ResponseWriter, b []byte, bulk func(records Records) (*Response, *StorageError)) {
+	records := Records{}
+	if e := json.Unmarshal(b, &records); e != nil {
+		MessageResponse(w, 400, "payload could not be parsed, expected an array of records")
+		return
+	}
+	result, err := bulk(records)
+	if err != nil {
+		MessageResponse(w, err.Code, err.Message)
+		return
+	}
+	status := 200
+	if result.Created != 0 {
+		status = 201
+		result.Message = "success"
+	}
+	result.Write(w, status)
+}
+
 func Handler(w http.ResponseWriter, r *http.Request, storage Storage) {
 
-	con := Context{Continue: true, Req: r, Write: w, Config: Config()}
+	//a path addressing no resource is refused before any filter judges it
+	if resourceSegments(r) == nil {
+		MessageResponse(w, 404, "resource not found")
+		return
+	}
 
+	con := Context{Continue: true, Req: r, Write: w, Config: Config(), Storage: storage}
 
-	AccessHeaders(&con)
-	Permissions(&con)
+	//each filter may stop the request, having written the response
+	for _, filter := range []func(*Context){AccessHeaders, Internal, Authenticate, Authorize} {
+		filter(&con)
+		if !con.Continue {
+			return
+		}
+	}
+	//filters may have added to the request's context, such as the caller's identity
+	r = con.Req
+
+	//storage operations end with the request, whether the client goes away or our timeout passes
+	if timeout := con.Config.QueryTimeout; timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
 
-	if !con.Continue {
+	if r.Method == "POST" && r.URL.Path == BatchPath {
+		HandleBatch(w, r, storage)
 		return
 	}
 
@@ -254,6 +728,8 @@ func Handler(w http.ResponseWriter, r *http.Request, storage Storage) {
 		HandlePost(w, r, storage)
 	case "DELETE":
 		HandleDelete(w, r, storage)
+	case "PATCH":
+		HandlePatch(w, r, storage)
 
 	case "OPTIONS":
 		MessageResponse(w, 200, "")
@@ -12,8 +12,25 @@ import (
 	"strings"
 	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
+	"time"
 )
 
+//without a configured database the integration tests run against a throwaway sqlite file
+func TestMain(m *testing.M) {
+	if _, ok := os.LookupEnv("VEIL_DB"); ok {
+		os.Exit(m.Run())
+	}
+	dir, err := ioutil.TempDir("", "veil")
+	check(err)
+	os.Setenv("VEIL_DB", "SQLITE")
+	os.Setenv("VEIL_DB_CONN", filepath.Join(dir, "veil.db"))
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 func TestUrlParse(t *testing.T) {
 
 	p := parsePath("/")
@@ -30,6 +47,31 @@ func TestUrlParse(t *testing.T) {
 	}
 }
 
+func TestParseFilter(t *testing.T) {
+	f, e := parseFilter("age[gte]", "30")
+	if e != nil || f.Column != "age" || f.Operator != Gte || f.Value != "30" {
+		t.Errorf("unexpected filter %v %v", f, e)
+	}
+	f, _ = parseFilter("name", "jo")
+	if f.Column != "name" || f.Operator != Eq {
+		t.Errorf("expected a plain parameter to be an equality, got %v", f)
+	}
+	f, _ = parseFilter("status[in]", "a,b")
+	if list, ok := f.Value.([]interface{}); !ok || len(list) != 2 {
+		t.Errorf("expected in to split its values, got %v", f.Value)
+	}
+	f, _ = parseFilter("deleted_at[null]", "true")
+	if f.Value != true {
+		t.Errorf("expected null to parse a bool, got %v", f.Value)
+	}
+	if _, e = parseFilter("deleted_at[null]", "maybe"); e == nil {
+		t.Errorf("expected an error for a non bool null check")
+	}
+	if _, e = parseFilter("age[between]", "1"); e == nil {
+		t.Errorf("expected an error for an unknown operator")
+	}
+}
+
 func request(method string, url string, data string) (*http.Response) {
 	request, err := http.NewRequest(method, url, strings.NewReader(data))
 	check(err)
@@ -45,33 +87,94 @@ func check(err error) {
 	}
 }
 
+//the database/sql driver for each VEIL_DB value
+var testDrivers = map[string]string{"MYSQL": "mysql", "POSTGRES": "postgres", "SQLITE": "sqlite3"}
+
+//the veil_test_resource definition for each VEIL_DB value
+var testTables = map[string]string{
+	"MYSQL": `CREATE TABLE veil_test_resource (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		test_field_1 varchar(255) NOT NULL,
+		test_field_2 varchar(255) NOT NULL,
+		PRIMARY KEY (id)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+	"POSTGRES": `CREATE TABLE veil_test_resource (
+		id SERIAL PRIMARY KEY,
+		test_field_1 varchar(255) NOT NULL,
+		test_field_2 varchar(255) NOT NULL
+	);`,
+	"SQLITE": `CREATE TABLE veil_test_resource (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		test_field_1 varchar(255) NOT NULL,
+		test_field_2 varchar(255) NOT NULL
+	);`,
+}
+
+//tables keyed by something other than an auto incremented id, for each VEIL_DB value
+//veil_test_legacy declares no key, it is given one with Configuration.PrimaryKeys
+var testKeyTables = map[string][]string{
+	"MYSQL": {
+		`CREATE TABLE veil_test_line (order_id int NOT NULL, line int NOT NULL, note varchar(255), PRIMARY KEY (order_id, line)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		`CREATE TABLE veil_test_sku (sku varchar(64) NOT NULL, name varchar(255), PRIMARY KEY (sku)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+		`CREATE TABLE veil_test_legacy (uuid varchar(64) NOT NULL, name varchar(255)) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+	},
+	"POSTGRES": {
+		`CREATE TABLE veil_test_line (order_id int NOT NULL, line int NOT NULL, note varchar(255), PRIMARY KEY (order_id, line));`,
+		`CREATE TABLE veil_test_sku (sku varchar(64) PRIMARY KEY, name varchar(255));`,
+		`CREATE TABLE veil_test_legacy (uuid varchar(64) NOT NULL, name varchar(255));`,
+	},
+	"SQLITE": {
+		`CREATE TABLE veil_test_line (order_id int NOT NULL, line int NOT NULL, note varchar(255), PRIMARY KEY (order_id, line));`,
+		`CREATE TABLE veil_test_sku (sku varchar(64) PRIMARY KEY, name varchar(255));`,
+		`CREATE TABLE veil_test_legacy (uuid varchar(64) NOT NULL, name varchar(255));`,
+	},
+}
+
+func testDB() *sql.DB {
+	db, err := sql.Open(testDrivers[Config().DB], Config().ConnectionString)
+	check(err)
+	return db
+}
+
 func initTestTable() {
 
-	db, err := sql.Open("mysql", Config().ConnectionString)
+	db := testDB()
 	defer db.Close()
 
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	_, err = db.Exec("DROP TABLE IF EXISTS `veil_test_resource`;")
+	_, err := db.Exec("DROP TABLE IF EXISTS veil_test_resource;")
 	check(err)
 
-	_, err = db.Exec(`CREATE TABLE veil_test_resource (		
-		id int(11) NOT NULL AUTO_INCREMENT,
-		test_field_1 varchar(255) NOT NULL,
-		test_field_2 varchar(255) NOT NULL,
-		PRIMARY KEY (id)
-	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`)
+	_, err = db.Exec(testTables[Config().DB])
 	check(err)
 }
 
+//creates the tables of testKeyTables and has the test storage see them
+func initTestKeyTables() {
+	db := testDB()
+	defer db.Close()
+
+	for _, table := range []string{"veil_test_line", "veil_test_sku", "veil_test_legacy"} {
+		_, err := db.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s;", table))
+		check(err)
+	}
+	for _, ddl := range testKeyTables[Config().DB] {
+		_, err := db.Exec(ddl)
+		check(err)
+	}
+
+	if reloader, ok := testStorage.(interface{ ReloadSchema() *StorageError }); ok {
+		if err := reloader.ReloadSchema(); err != nil {
+			log.Fatal("Error:", err)
+		}
+	}
+}
+
 func addXRows(x int) {
-	db, err := sql.Open("mysql", Config().ConnectionString)
+	db := testDB()
 	defer db.Close()
 	for i := 0; i < x; i++ {
 
-		_, err = db.Exec(fmt.Sprintf("INSERT INTO veil_test_resource (test_field_1, test_field_2) VALUES ('test_value_%d', 'test value %d');", i, i))
+		_, err := db.Exec(fmt.Sprintf("INSERT INTO veil_test_resource (test_field_1, test_field_2) VALUES ('test_value_%d', 'test value %d');", i, i))
 		check(err)
 	}
 }
@@ -81,15 +184,22 @@ func setUpIntegrationTest() {
 	addXRows(2)
 	config.PutPermissions = map[string]string{"global": "allow"}
 	config.PostPermissions = map[string]string{"global": "allow"}
+	config.PatchPermissions = map[string]string{"global": "allow"}
 	config.DeletePermissions = map[string]string{"global": "allow"}
 }
 
+//the storage shared by every integration test, like the one veil creates at startup
+var testStorage Storage
+
 func testHandlerFunc(w http.ResponseWriter, r *http.Request){
-	storage, err := NewStorage()
-	if err != nil {
-		log.Fatal(fmt.Sprint("Error connecting to database"))
+	if testStorage == nil {
+		storage, err := NewStorage()
+		if err != nil {
+			log.Fatal(fmt.Sprint("Error connecting to database"))
+		}
+		testStorage = storage
 	}
-	Handler(w, r, storage)
+	Handler(w, r, testStorage)
 }
 
 func TestAppHandleGET(t *testing.T) {
@@ -129,9 +239,26 @@ func TestAppHandleGET(t *testing.T) {
 		log.Fatal(fmt.Sprint("Tried receiving an uknown record by id, expected 404 received ", res.StatusCode))
 	}
 
+	//paths longer than /resource/key address nothing, the filters must judge the resource the handlers act on
+	res = request("GET", ts.URL+"/x/y/veil_test_resource", "")
+	res.Body.Close()
+	if res.StatusCode != 404 {
+		log.Fatal(fmt.Sprint("GET of a nested path expected a 404, got ", res.StatusCode))
+	}
+
 
 }
 
+//the href of the response link with the given rel, empty when there is none
+func linkHref(j Response, rel string) string {
+	for _, link := range j.Links {
+		if link.Rel == rel {
+			return link.Href
+		}
+	}
+	return ""
+}
+
 func loadResponseBody(res *http.Response) Response{
 	body, err := ioutil.ReadAll(res.Body)
 	res.Body.Close()
@@ -164,7 +291,125 @@ func TestAppHandlerGETWithLimitsAndOffsets(t *testing.T){
 		log.Fatal("Test App Handler GET did not return the right amount of records")
 	}
 
+	res = request("GET", ts.URL+"/veil_test_resource?offset=2&limit=2&count=true", "")
+	j = loadResponseBody(res)
+
+	if j.Meta == nil || j.Meta.Total != 5 || j.Meta.Offset != 2 || j.Meta.Limit != 2 {
+		log.Fatal(fmt.Sprint("Test App Handler GET did not count the records, got ", j.Meta))
+	}
+	if !strings.Contains(linkHref(j, "first"), "offset=0") || !strings.Contains(linkHref(j, "last"), "offset=4") {
+		log.Fatal(fmt.Sprint("Test App Handler GET did not link the first and last pages, got ", j.Links))
+	}
+
+	res = request("GET", ts.URL+"/veil_test_resource?count=maybe", "")
+	if res.StatusCode != 400 {
+		log.Fatal(fmt.Sprint("GET with a bad count expected a 400, got ", res.StatusCode))
+	}
+
+}
+func TestAppHandlerGETWithSortAndFields(t *testing.T){
+	initTestTable()
+	addXRows(5)
+	ts := httptest.NewServer(http.HandlerFunc(testHandlerFunc))
+	defer ts.Close()
+
+	res := request("GET", ts.URL+"/veil_test_resource?sort=-test_field_1&limit=2", "")
+	j := loadResponseBody(res)
+
+	if len(j.Data) != 2 || j.Data[0]["test_field_1"] != "test_value_4" || j.Data[1]["test_field_1"] != "test_value_3" {
+		log.Fatal("Test App Handler GET did not sort the records")
+	}
+	if !strings.Contains(linkHref(j, "next"), "sort=-test_field_1") {
+		log.Fatal("Test App Handler GET did not keep the sort in the next link")
+	}
+
+	res = request("GET", ts.URL+"/veil_test_resource?fields=id,test_field_2&limit=2", "")
+	j = loadResponseBody(res)
+
+	if len(j.Data[0]) != 2 || j.Data[0]["test_field_1"] != nil || !strings.Contains(linkHref(j, "next"), "fields=id%2Ctest_field_2") {
+		log.Fatal("Test App Handler GET did not honour the fields")
+	}
+
+	res = request("GET", ts.URL+"/veil_test_resource/1?fields=test_field_1", "")
+	j = loadResponseBody(res)
+
+	if len(j.Data) != 1 || len(j.Data[0]) != 1 || j.Data[0]["test_field_1"] != "test_value_0" {
+		log.Fatal("Test App Handler GET by id did not honour the fields")
+	}
+
+	res = request("GET", ts.URL+"/veil_test_resource?fields=id,password", "")
+	if res.StatusCode != 400 {
+		log.Fatal(fmt.Sprint("GET of an unknown field expected a 400, got ", res.StatusCode))
+	}
+
+	res = request("GET", ts.URL+"/veil_test_resource?sort=not_a_column", "")
+	if res.StatusCode != 400 {
+		log.Fatal(fmt.Sprint("GET sorted by an unknown column expected a 400, got ", res.StatusCode))
+	}
+}
+
+func TestAppHandlerGETWithCursor(t *testing.T){
+	initTestTable()
+	addXRows(5)
+	ts := httptest.NewServer(http.HandlerFunc(testHandlerFunc))
+	defer ts.Close()
+
+	var seen []interface{}
+	next := ts.URL + "/veil_test_resource?after=&limit=2&sort=-test_field_1&fields=test_field_2"
+	for next != "" {
+		res := request("GET", next, "")
+		j := loadResponseBody(res)
+		if res.StatusCode != 200 {
+			log.Fatal(fmt.Sprint("GET with a cursor expected a 200, got ", res.StatusCode))
+		}
+		for _, record := range j.Data {
+			if len(record) != 1 {
+				log.Fatal("Test App Handler GET with a cursor returned columns it was not asked for")
+			}
+			seen = append(seen, record["test_field_2"])
+		}
+		next = ""
+		for _, link := range j.Links {
+			if link.Rel == "next" {
+				next = link.Href
+			}
+			if link.Rel == "prev" || strings.Contains(link.Href, "offset=") {
+				log.Fatal("Test App Handler GET with a cursor returned an offset link")
+			}
+		}
+	}
+
+	if fmt.Sprint(seen) != "[test value 4 test value 3 test value 2 test value 1 test value 0]" {
+		log.Fatal(fmt.Sprint("Test App Handler GET with a cursor did not page through every record, got ", seen))
+	}
+
+	for _, cursor := range []string{"nonsense", encodeCursor(nil), encodeCursor([]interface{}{"test value 1"})} {
+		res := request("GET", ts.URL+"/veil_test_resource?sort=-test_field_1&after="+cursor, "")
+		res.Body.Close()
+		if res.StatusCode != 400 {
+			log.Fatal(fmt.Sprint("GET with a bad cursor expected a 400, got ", res.StatusCode))
+		}
+	}
+}
+
+func TestCursorQuery(t *testing.T) {
+	query := Query{Sort: []Sort{{Column: "name"}}}
+	if _, e := cursorQuery(&query, nil, ""); e == nil {
+		t.Errorf("a cursor on a resource without a primary key expected an error")
+	}
+
+	query = Query{Sort: []Sort{{Column: "name"}}}
+	if _, e := cursorQuery(&query, []string{"id"}, encodeCursor([]interface{}{"jo", 1})); e != nil || len(query.After) != 2 {
+		t.Errorf("a cursor matching the sort expected to be followed, got %v %v", query.After, e)
+	}
+	for _, cursor := range []string{encodeCursor(nil), encodeCursor([]interface{}{"jo"})} {
+		query = Query{Sort: []Sort{{Column: "name"}}}
+		if _, e := cursorQuery(&query, []string{"id"}, cursor); e == nil {
+			t.Errorf("a cursor %s not matching the sort expected an error, got %v", cursor, query.After)
+		}
+	}
 }
+
 func TestAppHandlerGETWithFilters(t *testing.T){
 	initTestTable()
 	addXRows(5)
@@ -178,6 +423,25 @@ func TestAppHandlerGETWithFilters(t *testing.T){
 		log.Fatal("Test App Handler GET did not return the right amount of records")
 	}
 
+	res = request("GET", ts.URL+"/veil_test_resource?test_field_1[in]=test_value_1,test_value_3&id[gt]=1", "")
+	j = loadResponseBody(res)
+
+	if len(j.Data) != 2 {
+		log.Fatal("Test App Handler GET with operators did not return the right amount of records")
+	}
+
+	res = request("GET", ts.URL+"/veil_test_resource?test_field_1[like]=test_value_%25&limit=2", "")
+	j = loadResponseBody(res)
+
+	if len(j.Data) != 2 || !strings.Contains(linkHref(j, "next"), "test_field_1%5Blike%5D=test_value_%25") {
+		log.Fatal("Test App Handler GET did not keep the filters in the next link")
+	}
+
+	res = request("GET", ts.URL+"/veil_test_resource?test_field_1[between]=a", "")
+	if res.StatusCode != 400 {
+		log.Fatal(fmt.Sprint("GET with an unknown operator expected a 400, got ", res.StatusCode))
+	}
+
 
 }
 
@@ -195,6 +459,23 @@ func TestAppHandlePUT(t *testing.T) {
 		log.Fatal(fmt.Sprint("PUT expected a 201, got ", res.StatusCode))
 	}
 
+	location := res.Header.Get("Location")
+	j := loadResponseBody(res)
+	if len(j.Data) != 1 || j.Data[0]["test_field_1"] != "fgfg" || j.Data[0]["id"] == nil {
+		log.Fatal(fmt.Sprint("PUT expected the created record, got ", j.Data))
+	}
+	if location != fmt.Sprint("/veil_test_resource/", j.Data[0]["id"]) {
+		log.Fatal(fmt.Sprint("PUT expected a Location of the created record, got ", location))
+	}
+	if linkHref(j, "self") != "http://"+res.Request.URL.Host+location {
+		log.Fatal(fmt.Sprint("PUT expected a self link to the created record, got ", j.Links))
+	}
+
+	j = loadResponseBody(request("GET", ts.URL+location, ""))
+	if len(j.Data) != 1 || j.Data[0]["test_field_1"] != "fgfg" {
+		log.Fatal(fmt.Sprint("PUT Location could not be read back, got ", j.Data))
+	}
+
 	res = request("PUT", ts.URL+"/veil_test_not_exist", "{\"test_field_1\":\"t\"}")
 	if res.StatusCode != 404 {
 		log.Fatal(fmt.Sprint("GET expected a 404, got ", res.StatusCode))
@@ -205,6 +486,21 @@ func TestAppHandlePUT(t *testing.T) {
 	if res.StatusCode != 400 {
 		log.Fatal(fmt.Sprint("GET expected a 400, got ", res.StatusCode))
 	}
+
+	res = request("PUT", ts.URL+"/veil_test_resource/50", "{\"test_field_1\":\"put\", \"test_field_2\":\"put\"}")
+	if res.StatusCode != 201 || loadResponseBody(res).Created != 1 || res.Header.Get("Location") != "/veil_test_resource/50" {
+		log.Fatal(fmt.Sprint("PUT to a new id expected a 201 with 1 created at its Location, got ", res.StatusCode))
+	}
+
+	res = request("PUT", ts.URL+"/veil_test_resource/50", "{\"test_field_1\":\"replaced\", \"test_field_2\":\"put\"}")
+	if res.StatusCode != 200 || loadResponseBody(res).Updated != 1 {
+		log.Fatal(fmt.Sprint("PUT to an existing id expected a 200 with 1 updated, got ", res.StatusCode))
+	}
+
+	j = loadResponseBody(request("GET", ts.URL+"/veil_test_resource/50", ""))
+	if len(j.Data) != 1 || j.Data[0]["test_field_1"] != "replaced" {
+		log.Fatal(fmt.Sprint("PUT to an id was not read back, got ", j.Data))
+	}
 }
 
 func TestAppHandlePOST(t *testing.T) {
@@ -238,6 +534,69 @@ func TestAppHandlePOST(t *testing.T) {
 }
 
 
+func patchRequest(url string, contentType string, data string) *http.Response {
+	request, err := http.NewRequest("PATCH", url, strings.NewReader(data))
+	check(err)
+	request.Header.Set("Content-Type", contentType)
+	response, err := http.DefaultClient.Do(request)
+	check(err)
+	return response
+}
+
+func TestAppHandlePATCH(t *testing.T) {
+
+	setUpIntegrationTest()
+
+	ts := httptest.NewServer(http.HandlerFunc(testHandlerFunc))
+	defer ts.Close()
+
+	res := patchRequest(ts.URL+"/veil_test_resource/1", MergePatchContentType, "{\"test_field_2\":\"merged\"}")
+	if res.StatusCode != 200 || loadResponseBody(res).Updated != 1 {
+		log.Fatal(fmt.Sprint("PATCH merge expected a 200 with 1 update, got ", res.StatusCode))
+	}
+
+	j := loadResponseBody(request("GET", ts.URL+"/veil_test_resource/1", ""))
+	if j.Data[0]["test_field_1"] != "test_value_0" || j.Data[0]["test_field_2"] != "merged" {
+		log.Fatal(fmt.Sprint("PATCH merge did not leave absent keys untouched, got ", j.Data))
+	}
+
+	res = patchRequest(ts.URL+"/veil_test_resource/1", JSONPatchContentType, `[
+		{"op": "test", "path": "/test_field_2", "value": "merged"},
+		{"op": "copy", "from": "/test_field_2", "path": "/test_field_1"},
+		{"op": "replace", "path": "/test_field_2", "value": "patched"}
+	]`)
+	if res.StatusCode != 200 {
+		log.Fatal(fmt.Sprint("PATCH json expected a 200, got ", res.StatusCode))
+	}
+
+	j = loadResponseBody(request("GET", ts.URL+"/veil_test_resource/1", ""))
+	if j.Data[0]["test_field_1"] != "merged" || j.Data[0]["test_field_2"] != "patched" {
+		log.Fatal(fmt.Sprint("PATCH json was not applied, got ", j.Data))
+	}
+
+	res = patchRequest(ts.URL+"/veil_test_resource/1", JSONPatchContentType, `[{"op": "test", "path": "/test_field_2", "value": "merged"}]`)
+	if res.StatusCode != 409 {
+		log.Fatal(fmt.Sprint("PATCH with a failing test expected a 409, got ", res.StatusCode))
+	}
+
+	res = patchRequest(ts.URL+"/veil_test_resource/11", MergePatchContentType, "{\"test_field_2\":\"merged\"}")
+	if res.StatusCode != 404 {
+		log.Fatal(fmt.Sprint("PATCH of an unknown record expected a 404, got ", res.StatusCode))
+	}
+
+	res = patchRequest(ts.URL+"/veil_test_resource/1", "application/json", "{\"test_field_2\":\"merged\"}")
+	if res.StatusCode != 415 {
+		log.Fatal(fmt.Sprint("PATCH with plain json expected a 415, got ", res.StatusCode))
+	}
+
+	config.PatchPermissions = map[string]string{"global": "deny"}
+	res = patchRequest(ts.URL+"/veil_test_resource/1", MergePatchContentType, "{\"test_field_2\":\"merged\"}")
+	config.PatchPermissions = map[string]string{"global": "allow"}
+	if res.StatusCode != 401 {
+		log.Fatal(fmt.Sprint("PATCH without permission expected a 401, got ", res.StatusCode))
+	}
+}
+
 func TestAppHandleDELETE(t *testing.T) {
 
 	setUpIntegrationTest()
@@ -265,7 +624,224 @@ func TestAppHandleDELETE(t *testing.T) {
 		log.Fatal("delete failed")
 	}
 
+	res = request("DELETE", ts.URL+"/x/veil_test_resource/2", "")
+	res.Body.Close()
+	if res.StatusCode != 404 {
+		log.Fatal(fmt.Sprint("DELETE of a nested path expected a 404, got ", res.StatusCode))
+	}
+	res = request("GET", ts.URL+"/veil_test_resource/2", "")
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		log.Fatal(fmt.Sprint("DELETE of a nested path expected the record kept, got ", res.StatusCode))
+	}
+
+	//the path is refused before permissions are judged, so it is a 404 whoever asks
+	config.DeletePermissions = map[string]string{"global": "deny"}
+	res = request("DELETE", ts.URL+"/x/veil_test_resource/2", "")
+	res.Body.Close()
+	config.DeletePermissions = map[string]string{"global": "allow"}
+	if res.StatusCode != 404 {
+		log.Fatal(fmt.Sprint("a denied DELETE of a nested path expected a 404, got ", res.StatusCode))
+	}
+
 }
 
 
 
+
+func TestAppHandleBulk(t *testing.T) {
+
+	setUpIntegrationTest()
+
+	ts := httptest.NewServer(http.HandlerFunc(testHandlerFunc))
+	defer ts.Close()
+
+	res := request("PUT", ts.URL+"/veil_test_resource", `[{"test_field_1":"bulk_0","test_field_2":"bulk"},{"test_field_1":"bulk_1","test_field_2":"bulk"}]`)
+	j := loadResponseBody(res)
+	if res.StatusCode != 201 || j.Created != 2 || len(j.Items) != 2 || len(j.Data) != 2 {
+		log.Fatal(fmt.Sprint("bulk PUT expected a 201 with 2 created, got ", res.StatusCode, j))
+	}
+
+	res = request("POST", ts.URL+"/veil_test_resource", fmt.Sprintf(`[{"id":%v,"test_field_2":"updated"},{"id":1,"test_field_2":"updated"},{"id":99,"test_field_2":"updated"}]`, j.Items[0].Id))
+	j = loadResponseBody(res)
+	if res.StatusCode != 200 || j.Updated != 2 || len(j.Items) != 3 || j.Items[2].Status != 404 {
+		log.Fatal(fmt.Sprint("bulk POST expected a 200 with 2 updated and an unknown id, got ", res.StatusCode, j))
+	}
+
+	j = loadResponseBody(request("GET", ts.URL+"/veil_test_resource?test_field_2=updated", ""))
+	if len(j.Data) != 2 {
+		log.Fatal(fmt.Sprint("bulk POST expected 2 updated records, got ", j.Data))
+	}
+
+	res = request("DELETE", ts.URL+"/veil_test_resource?id[in]=1,2,99", "")
+	j = loadResponseBody(res)
+	if res.StatusCode != 200 || j.Deleted != 2 || len(j.Items) != 2 {
+		log.Fatal(fmt.Sprint("bulk DELETE expected a 200 with 2 deleted, got ", res.StatusCode, j))
+	}
+
+	res = request("DELETE", ts.URL+"/veil_test_resource", "")
+	if res.StatusCode != 400 {
+		log.Fatal(fmt.Sprint("bulk DELETE without a filter expected a 400, got ", res.StatusCode))
+	}
+
+	res = request("PUT", ts.URL+"/veil_test_resource", `[{"test_field_1":"bulk_2","test_field_2":"bulk"},{"test_field_1":"bulk_3"}]`)
+	if res.StatusCode != 400 {
+		log.Fatal(fmt.Sprint("bulk PUT with an incomplete record expected a 400, got ", res.StatusCode))
+	}
+	j = loadResponseBody(request("GET", ts.URL+"/veil_test_resource?test_field_1=bulk_2", ""))
+	if len(j.Data) != 0 {
+		log.Fatal(fmt.Sprint("a failed bulk PUT expected nothing created, got ", j.Data))
+	}
+}
+
+func TestAppHandleBatch(t *testing.T) {
+
+	setUpIntegrationTest()
+
+	ts := httptest.NewServer(http.HandlerFunc(testHandlerFunc))
+	defer ts.Close()
+
+	res := request("POST", ts.URL+"/_batch", `[
+		{"method": "PUT", "path": "/veil_test_resource", "body": {"test_field_1": "order", "test_field_2": "batch"}},
+		{"method": "PUT", "path": "/veil_test_resource", "body": [{"test_field_1": "item_0", "test_field_2": "batch"}, {"test_field_1": "item_1", "test_field_2": "batch"}]},
+		{"method": "PATCH", "path": "/veil_test_resource/1", "content_type": "application/merge-patch+json", "body": {"test_field_2": "batch"}}
+	]`)
+	var responses []Response
+	check(json.NewDecoder(res.Body).Decode(&responses))
+	if res.StatusCode != 200 || len(responses) != 3 || responses[0].Created != 1 || responses[1].Created != 2 || responses[2].Updated != 1 {
+		log.Fatal(fmt.Sprint("batch expected a 200 with every response, got ", res.StatusCode, responses))
+	}
+
+	j := loadResponseBody(request("GET", ts.URL+"/veil_test_resource?test_field_2=batch", ""))
+	if len(j.Data) != 4 {
+		log.Fatal(fmt.Sprint("batch expected its writes committed, got ", j.Data))
+	}
+
+	res = request("POST", ts.URL+"/_batch", `[
+		{"method": "PUT", "path": "/veil_test_resource", "body": {"test_field_1": "rolled_back", "test_field_2": "batch"}},
+		{"method": "DELETE", "path": "/veil_test_resource/2"},
+		{"method": "PUT", "path": "/veil_test_resource", "body": {"test_field_1": "incomplete"}},
+		{"method": "PUT", "path": "/veil_test_resource", "body": {"test_field_1": "never", "test_field_2": "batch"}}
+	]`)
+	responses = nil
+	check(json.NewDecoder(res.Body).Decode(&responses))
+	if res.StatusCode != 400 || len(responses) != 3 || responses[2].Status != 400 {
+		log.Fatal(fmt.Sprint("batch with a failing operation expected a 400 ending at the failure, got ", res.StatusCode, responses))
+	}
+
+	j = loadResponseBody(request("GET", ts.URL+"/veil_test_resource?test_field_2=batch", ""))
+	if len(j.Data) != 4 {
+		log.Fatal(fmt.Sprint("a failed batch expected its writes rolled back, got ", j.Data))
+	}
+	j = loadResponseBody(request("GET", ts.URL+"/veil_test_resource/2", ""))
+	if len(j.Data) != 1 {
+		log.Fatal("a failed batch expected its delete rolled back")
+	}
+
+	res = request("POST", ts.URL+"/_batch", `[{"method": "POST", "path": "/_batch", "body": []}]`)
+	if res.StatusCode != 400 {
+		log.Fatal(fmt.Sprint("a nested batch expected a 400, got ", res.StatusCode))
+	}
+}
+
+func TestAppHandleQueryTimeout(t *testing.T) {
+
+	setUpIntegrationTest()
+
+	ts := httptest.NewServer(http.HandlerFunc(testHandlerFunc))
+	defer ts.Close()
+
+	timeout := config.QueryTimeout
+	config.QueryTimeout = time.Nanosecond
+	res := request("GET", ts.URL+"/veil_test_resource", "")
+	config.QueryTimeout = timeout
+	if res.StatusCode != 504 {
+		log.Fatal(fmt.Sprint("GET past the query timeout expected a 504, got ", res.StatusCode))
+	}
+}
+
+func TestAppHandlePrimaryKeys(t *testing.T) {
+
+	setUpIntegrationTest()
+	config.PrimaryKeys = map[string][]string{"veil_test_legacy": {"uuid"}}
+	defer func() { config.PrimaryKeys = map[string][]string{} }()
+	initTestKeyTables()
+
+	ts := httptest.NewServer(http.HandlerFunc(testHandlerFunc))
+	defer ts.Close()
+
+	res := request("PUT", ts.URL+"/veil_test_line/12,3", `{"note":"first"}`)
+	if res.StatusCode != 201 || res.Header.Get("Location") != "/veil_test_line/12,3" {
+		log.Fatal(fmt.Sprint("PUT of a composite key expected a 201 located at /veil_test_line/12,3, got ", res.StatusCode, res.Header.Get("Location")))
+	}
+	res = request("PUT", ts.URL+"/veil_test_line", `{"order_id":12,"line":4,"note":"second"}`)
+	j := loadResponseBody(res)
+	if res.StatusCode != 201 || len(j.Data) != 1 || res.Header.Get("Location") != "/veil_test_line/12,4" {
+		log.Fatal(fmt.Sprint("PUT of a record with a composite key expected a 201 with the record, got ", res.StatusCode, j))
+	}
+
+	res = request("POST", ts.URL+"/veil_test_line/12,3", `{"note":"updated"}`)
+	if res.StatusCode != 200 || loadResponseBody(res).Updated != 1 {
+		log.Fatal(fmt.Sprint("POST to a composite key expected a 200 with 1 update, got ", res.StatusCode))
+	}
+	j = loadResponseBody(request("GET", ts.URL+"/veil_test_line/12,3", ""))
+	if len(j.Data) != 1 || j.Data[0]["note"] != "updated" {
+		log.Fatal(fmt.Sprint("GET of a composite key expected the updated record, got ", j.Data))
+	}
+
+	res = request("GET", ts.URL+"/veil_test_line/12", "")
+	if res.StatusCode != 400 {
+		log.Fatal(fmt.Sprint("GET of a partial composite key expected a 400, got ", res.StatusCode))
+	}
+	res = patchRequest(ts.URL+"/veil_test_line/12,3", MergePatchContentType, `{"line":5}`)
+	if res.StatusCode != 400 {
+		log.Fatal(fmt.Sprint("PATCH of a key column expected a 400, got ", res.StatusCode))
+	}
+
+	res = request("POST", ts.URL+"/veil_test_line", `[{"order_id":12,"line":4,"note":"bulk"},{"order_id":12,"line":9,"note":"bulk"}]`)
+	j = loadResponseBody(res)
+	if res.StatusCode != 200 || j.Updated != 1 || len(j.Items) != 2 || j.Items[0].Id != "12,4" || j.Items[1].Status != 404 {
+		log.Fatal(fmt.Sprint("bulk POST of composite keys expected 1 update and an unknown key, got ", res.StatusCode, j))
+	}
+
+	res = request("DELETE", ts.URL+"/veil_test_line/12,4", "")
+	if res.StatusCode != 200 {
+		log.Fatal(fmt.Sprint("DELETE of a composite key expected a 200, got ", res.StatusCode))
+	}
+	res = request("GET", ts.URL+"/veil_test_line/12,4", "")
+	if res.StatusCode != 404 {
+		log.Fatal(fmt.Sprint("GET of a deleted composite key expected a 404, got ", res.StatusCode))
+	}
+
+	res = request("PUT", ts.URL+"/veil_test_sku/ab%2C1", `{"name":"widget"}`)
+	if res.StatusCode != 201 || res.Header.Get("Location") != "/veil_test_sku/ab%2C1" {
+		log.Fatal(fmt.Sprint("PUT of a text key expected a 201 located at /veil_test_sku/ab%2C1, got ", res.StatusCode, res.Header.Get("Location")))
+	}
+	j = loadResponseBody(request("GET", ts.URL+"/veil_test_sku/ab%2C1", ""))
+	if len(j.Data) != 1 || j.Data[0]["sku"] != "ab,1" {
+		log.Fatal(fmt.Sprint("GET of a text key expected the record, got ", j.Data))
+	}
+
+	res = request("PUT", ts.URL+"/veil_test_legacy", `{"uuid":"u-1","name":"legacy"}`)
+	if res.StatusCode != 201 || res.Header.Get("Location") != "/veil_test_legacy/u-1" {
+		log.Fatal(fmt.Sprint("PUT to a table keyed by configuration expected a 201 located at /veil_test_legacy/u-1, got ", res.StatusCode, res.Header.Get("Location")))
+	}
+	//the database doesn't enforce a configured key, so a repeated PUT must still replace rather than add a row
+	res = request("PUT", ts.URL+"/veil_test_legacy/u-2", `{"name":"first"}`)
+	if res.StatusCode != 201 {
+		log.Fatal(fmt.Sprint("PUT of a new configured key expected a 201, got ", res.StatusCode))
+	}
+	res = request("PUT", ts.URL+"/veil_test_legacy/u-2", `{"name":"second"}`)
+	if res.StatusCode != 200 || loadResponseBody(res).Updated != 1 {
+		log.Fatal(fmt.Sprint("PUT of an existing configured key expected a 200 with 1 updated, got ", res.StatusCode))
+	}
+	j = loadResponseBody(request("GET", ts.URL+"/veil_test_legacy?uuid=u-2", ""))
+	if len(j.Data) != 1 || j.Data[0]["name"] != "second" {
+		log.Fatal(fmt.Sprint("PUT twice to a configured key expected one replaced row, got ", j.Data))
+	}
+
+	res = request("DELETE", ts.URL+"/veil_test_legacy/u-1", "")
+	if res.StatusCode != 200 || loadResponseBody(res).Deleted != 1 {
+		log.Fatal(fmt.Sprint("DELETE from a table keyed by configuration expected a 200, got ", res.StatusCode))
+	}
+}
@@ -0,0 +1,217 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"time"
+)
+
+//the header of a json web token
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+//a single key of a json web key set, only the members of RSA and P-256 keys are read
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+//reads the public keys of a json web key set file by key id, keys without an id are kept under ""
+//key types other than RSA and P-256 EC are skipped
+func loadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	b, e := ioutil.ReadFile(path)
+	if e != nil {
+		return nil, e
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if e = json.Unmarshal(b, &set); e != nil {
+		return nil, e
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		switch {
+		case k.Kty == "RSA":
+			n, e := decodeBigInt(k.N)
+			if e != nil {
+				return nil, fmt.Errorf("key '%s' has an improper modulus", k.Kid)
+			}
+			exponent, e := decodeBigInt(k.E)
+			if e != nil || !exponent.IsInt64() {
+				return nil, fmt.Errorf("key '%s' has an improper exponent", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(exponent.Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, e := decodeBigInt(k.X)
+			if e != nil {
+				return nil, fmt.Errorf("key '%s' has an improper x coordinate", k.Kid)
+			}
+			y, e := decodeBigInt(k.Y)
+			if e != nil {
+				return nil, fmt.Errorf("key '%s' has an improper y coordinate", k.Kid)
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key '%s' is not on its curve", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, e := base64.RawURLEncoding.DecodeString(s)
+	if e != nil {
+		return nil, e
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+//verifies a compact json web token and returns its claims
+//HS256 tokens are checked against the configured secret, RS256 and ES256 tokens against the key named by their kid
+//the key must be of the type the algorithm expects, so a public key can't be passed off as an HMAC secret
+func verifyJWT(token string, conf *Configuration, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	headerJSON, e := base64.RawURLEncoding.DecodeString(parts[0])
+	if e != nil {
+		return nil, errors.New("malformed header")
+	}
+	var header jwtHeader
+	if e = json.Unmarshal(headerJSON, &header); e != nil {
+		return nil, errors.New("malformed header")
+	}
+	signature, e := base64.RawURLEncoding.DecodeString(parts[2])
+	if e != nil {
+		return nil, errors.New("malformed signature")
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(signed)
+	switch header.Alg {
+	case "HS256":
+		if conf.JWTSecret == "" {
+			return nil, errors.New("HS256 tokens are not accepted")
+		}
+		mac := hmac.New(sha256.New, []byte(conf.JWTSecret))
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, errors.New("invalid signature")
+		}
+	case "RS256":
+		key, ok := conf.JWTKeys[header.Kid].(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("no RSA key '%s'", header.Kid)
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return nil, errors.New("invalid signature")
+		}
+	case "ES256":
+		key, ok := conf.JWTKeys[header.Kid].(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("no EC key '%s'", header.Kid)
+		}
+		//the signature is r and s as two 32 byte big endian integers
+		if len(signature) != 64 {
+			return nil, errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(key, digest[:], r, s) {
+			return nil, errors.New("invalid signature")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported algorithm '%s'", header.Alg)
+	}
+
+	payload, e := base64.RawURLEncoding.DecodeString(parts[1])
+	if e != nil {
+		return nil, errors.New("malformed claims")
+	}
+	var claims map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(payload))
+	d.UseNumber()
+	if e = d.Decode(&claims); e != nil {
+		return nil, errors.New("malformed claims")
+	}
+	return claims, checkClaims(claims, conf, now)
+}
+
+//checks the registered claims of a verified token, exp and nbf are honoured when present and iss and aud when configured
+func checkClaims(claims map[string]interface{}, conf *Configuration, now time.Time) error {
+	if exp, ok := claims["exp"]; ok {
+		t, e := claimTime(exp)
+		if e != nil || !now.Before(t) {
+			return errors.New("token has expired")
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		t, e := claimTime(nbf)
+		if e != nil || now.Before(t) {
+			return errors.New("token is not valid yet")
+		}
+	}
+	if conf.JWTIssuer != "" && claims["iss"] != conf.JWTIssuer {
+		return errors.New("unexpected issuer")
+	}
+	if conf.JWTAudience != "" && !contains(claimStrings(claims["aud"]), conf.JWTAudience) {
+		return errors.New("unexpected audience")
+	}
+	return nil
+}
+
+//the time of a NumericDate claim
+func claimTime(v interface{}) (time.Time, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, errors.New("not a number")
+	}
+	f, e := n.Float64()
+	if e != nil {
+		return time.Time{}, e
+	}
+	return time.Unix(int64(f), 0), nil
+}
+
+//the strings of a claim that may be a single string or an array of them
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var values []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
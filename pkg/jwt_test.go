@@ -0,0 +1,191 @@
+package pkg
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func encodeSegment(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+//signs the claims with the algorithm, key is the HMAC secret or the private key
+func signJWT(t *testing.T, alg string, kid string, key interface{}, claims map[string]interface{}) string {
+	signed := encodeSegment(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		s, e := rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		if e != nil {
+			t.Fatal(e)
+		}
+		signature = s
+	case "ES256":
+		r, s, e := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		if e != nil {
+			t.Fatal(e)
+		}
+		signature = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rb):32], rb)
+		copy(signature[64-len(sb):], sb)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func bigSegment(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestVerifyJWT(t *testing.T) {
+	rsaKey, e := rsa.GenerateKey(rand.Reader, 2048)
+	if e != nil {
+		t.Fatal(e)
+	}
+	ecKey, e := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	dir, e := ioutil.TempDir("", "veil")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	jwks := filepath.Join(dir, "jwks.json")
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "n": bigSegment(rsaKey.N), "e": bigSegment(big.NewInt(int64(rsaKey.E)))},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": bigSegment(ecKey.X), "y": bigSegment(ecKey.Y)},
+		{"kty": "oct", "kid": "skipped"},
+	}}
+	b, _ := json.Marshal(set)
+	if e = ioutil.WriteFile(jwks, b, 0600); e != nil {
+		t.Fatal(e)
+	}
+	keys, e := loadJWKS(jwks)
+	if e != nil || len(keys) != 2 {
+		t.Fatalf("expected 2 keys from the JWKS file, got %v %v", keys, e)
+	}
+
+	secret := []byte("shared secret")
+	conf := &Configuration{JWTSecret: string(secret), JWTKeys: keys, JWTIssuer: "veil-test", JWTAudience: "veil"}
+	now := time.Now()
+	claims := map[string]interface{}{"sub": "jo", "iss": "veil-test", "aud": []string{"other", "veil"}, "exp": now.Add(time.Minute).Unix()}
+
+	valid := map[string]string{
+		"HS256": signJWT(t, "HS256", "", secret, claims),
+		"RS256": signJWT(t, "RS256", "rsa", rsaKey, claims),
+		"ES256": signJWT(t, "ES256", "ec", ecKey, claims),
+	}
+	for alg, token := range valid {
+		verified, e := verifyJWT(token, conf, now)
+		if e != nil || verified["sub"] != "jo" {
+			t.Errorf("%s expected the token verified, got %v %v", alg, verified, e)
+		}
+	}
+
+	expired := map[string]interface{}{"sub": "jo", "iss": "veil-test", "aud": "veil", "exp": now.Add(-time.Minute).Unix()}
+	early := map[string]interface{}{"sub": "jo", "iss": "veil-test", "aud": "veil", "nbf": now.Add(time.Minute).Unix()}
+	stranger := map[string]interface{}{"sub": "jo", "iss": "elsewhere", "aud": "veil"}
+	invalid := map[string]string{
+		"expired":             signJWT(t, "HS256", "", secret, expired),
+		"not yet valid":       signJWT(t, "HS256", "", secret, early),
+		"wrong issuer":        signJWT(t, "HS256", "", secret, stranger),
+		"wrong secret":        signJWT(t, "HS256", "", []byte("guessed"), claims),
+		"unknown key":         signJWT(t, "RS256", "missing", rsaKey, claims),
+		"mismatched key type": signJWT(t, "ES256", "rsa", ecKey, claims),
+		"tampered claims":     strings.Replace(valid["RS256"], encodeSegment(claims), encodeSegment(map[string]interface{}{"sub": "admin"}), 1),
+		"unsigned":            encodeSegment(map[string]string{"alg": "none"}) + "." + encodeSegment(claims) + ".",
+		"malformed":           "not.a-token",
+	}
+	for name, token := range invalid {
+		if _, e := verifyJWT(token, conf, now); e == nil {
+			t.Errorf("%s token expected to be refused", name)
+		}
+	}
+}
+
+//a storage recording the identity its reads were made for
+type identityRecordingStorage struct {
+	*MemoryStorage
+	identity *Identity
+}
+
+func (s *identityRecordingStorage) Read(ctx context.Context, resource Resource, query *Query) (*Response, *StorageError) {
+	s.identity = IdentityFrom(ctx)
+	return s.MemoryStorage.Read(ctx, resource, query)
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("shared secret")
+	defer func(required bool, s string) {
+		Config().AuthRequired, Config().JWTSecret = required, s
+	}(Config().AuthRequired, Config().JWTSecret)
+	Config().JWTSecret = string(secret)
+
+	storage := &identityRecordingStorage{MemoryStorage: newTestMemoryStorage(1)}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handler(w, r, storage)
+	}))
+	defer ts.Close()
+
+	get := func(authorization string) *http.Response {
+		req, _ := http.NewRequest("GET", ts.URL+"/veil_test_resource", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		res, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatal(e)
+		}
+		res.Body.Close()
+		return res
+	}
+
+	token := signJWT(t, "HS256", "", secret, map[string]interface{}{"sub": "jo", "roles": []string{"editor"}, "scope": "read write"})
+	if res := get("Bearer " + token); res.StatusCode != 200 {
+		t.Fatalf("a valid token expected a 200, got %d", res.StatusCode)
+	}
+	if storage.identity == nil || storage.identity.Subject != "jo" || len(storage.identity.Roles) != 1 || len(storage.identity.Scopes) != 2 {
+		t.Errorf("storage expected the caller's identity, got %+v", storage.identity)
+	}
+
+	res := get("Bearer " + token + "x")
+	if res.StatusCode != 401 || res.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("an invalid token expected a 401 asking for a bearer token, got %d", res.StatusCode)
+	}
+	if res := get("Basic am86cHc="); res.StatusCode != 401 {
+		t.Errorf("a basic credential expected a 401, got %d", res.StatusCode)
+	}
+
+	if res := get(""); res.StatusCode != 200 || storage.identity != nil {
+		t.Errorf("an anonymous caller expected a 200 without an identity, got %d %+v", res.StatusCode, storage.identity)
+	}
+	Config().AuthRequired = true
+	if res := get(""); res.StatusCode != 401 {
+		t.Errorf("an anonymous caller expected a 401 when authentication is required, got %d", res.StatusCode)
+	}
+}
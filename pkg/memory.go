@@ -0,0 +1,710 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//a Storage held entirely in go maps, intended for tests and prototyping
+//resources have to be declared with AddResource before they can be used
+type MemoryStorage struct {
+	lock      sync.RWMutex
+	resources map[string]*memoryResource
+}
+
+type memoryResource struct {
+	*Table            //the columns a record may hold
+	required []string //the columns a record must hold when created
+	unique   []string //the columns no two records may share a value of
+	records  Records  //our records in insertion order
+	nextId   int64    //the auto increment value of the next created record
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{resources: map[string]*memoryResource{}}
+}
+
+//declares a resource with the given columns, an auto incremented id column is always present and is its key
+//redeclaring a resource drops its records
+func (m *MemoryStorage) AddResource(resource Resource, columns []string, required []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	table := Table{Name: resource.Identifier, Columns: append([]string{"id"}, columns...), Key: []string{"id"}}
+	m.resources[resource.Identifier] = &memoryResource{Table: &table, required: required, nextId: 1}
+}
+
+//declares columns of the resource no two records may share a value of, like a unique index nulls are never shared
+func (m *MemoryStorage) AddUnique(resource Resource, columns ...string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if r, ok := m.resources[resource.Identifier]; ok {
+		r.unique = append(r.unique, columns...)
+	}
+}
+
+func (m *MemoryStorage) resource(resource Resource) (*memoryResource, *StorageError) {
+	r, ok := m.resources[resource.Identifier]
+	if !ok {
+		return nil, &StorageError{Code: 404, Message: "resource not found"}
+	}
+	return r, nil
+}
+
+func (m *MemoryStorage) Describe(resource Resource) (*Table, *StorageError) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	r, err := m.resource(resource)
+	if err != nil {
+		return nil, err
+	}
+	return r.Table, nil
+}
+
+//values arrive from urls as strings, so they are compared by their printed form
+func sameValue(a interface{}, b interface{}) bool {
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+//orders two values numerically when both are numbers, otherwise by their printed form
+func compareValues(a interface{}, b interface{}) int {
+	af, aErr := strconv.ParseFloat(fmt.Sprint(a), 64)
+	bf, bErr := strconv.ParseFloat(fmt.Sprint(b), 64)
+	if aErr == nil && bErr == nil {
+		switch {
+		case af < bf:
+			return -1
+		case af > bf:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+//translates a LIKE pattern into a case insensitive regular expression
+func likePattern(pattern string) *regexp.Regexp {
+	expr := "(?is)^"
+	for _, c := range pattern {
+		switch c {
+		case '%':
+			expr += ".*"
+		case '_':
+			expr += "."
+		default:
+			expr += regexp.QuoteMeta(string(c))
+		}
+	}
+	return regexp.MustCompile(expr + "$")
+}
+
+//whether the record meets the filter, like sql a missing or null value meets nothing but a null check
+func meets(record Record, f Filter) (bool, *StorageError) {
+	v := record[f.Column]
+	if f.Operator == Null {
+		isNull, _ := f.Value.(bool)
+		return (v == nil) == isNull, nil
+	}
+	if v == nil {
+		return false, nil
+	}
+
+	switch f.Operator {
+	case Eq:
+		return sameValue(v, f.Value), nil
+	case Ne:
+		return !sameValue(v, f.Value), nil
+	case Gt:
+		return compareValues(v, f.Value) > 0, nil
+	case Gte:
+		return compareValues(v, f.Value) >= 0, nil
+	case Lt:
+		return compareValues(v, f.Value) < 0, nil
+	case Lte:
+		return compareValues(v, f.Value) <= 0, nil
+	case Like:
+		return likePattern(fmt.Sprint(f.Value)).MatchString(fmt.Sprint(v)), nil
+	case In:
+		list, ok := f.Value.([]interface{})
+		if !ok {
+			return false, &StorageError{Code: 400, Message: fmt.Sprintf("'%s' expects a list", f.Operator)}
+		}
+		for _, item := range list {
+			if sameValue(v, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return false, &StorageError{Code: 400, Message: fmt.Sprintf("unknown operator '%s'", f.Operator)}
+}
+
+//the values of the record's sort key columns
+func sortValues(record Record, keys []Sort) []interface{} {
+	var values []interface{}
+	for _, k := range keys {
+		values = append(values, record[k.Column])
+	}
+	return values
+}
+
+//orders two sets of sort key values, nulls come first
+func compareSortValues(a []interface{}, b []interface{}, keys []Sort) int {
+	for i, k := range keys {
+		c := 0
+		switch {
+		case a[i] == nil && b[i] == nil:
+		case a[i] == nil:
+			c = -1
+		case b[i] == nil:
+			c = 1
+		default:
+			c = compareValues(a[i], b[i])
+		}
+		if k.Descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+//returns a copy of the records ordered by the sort keys
+func sortRecords(records Records, keys []Sort) Records {
+	sorted := append(Records{}, records...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return compareSortValues(sortValues(sorted[i], keys), sortValues(sorted[j], keys), keys) < 0
+	})
+	return sorted
+}
+
+//whether the record meets every filter
+func meetsAll(record Record, filters []Filter) (bool, *StorageError) {
+	for _, f := range filters {
+		ok, err := meets(record, f)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func copyRecord(record Record) Record {
+	c := Record{}
+	for k, v := range record {
+		c[k] = v
+	}
+	return c
+}
+
+//copies the given fields of the record, or all of them when none are given
+func project(record Record, fields []string) Record {
+	if len(fields) == 0 {
+		return copyRecord(record)
+	}
+	p := Record{}
+	for _, f := range fields {
+		p[f] = record[f]
+	}
+	return p
+}
+
+func (m *MemoryStorage) Create(ctx context.Context, resource Resource, record Record) (*Response, *StorageError) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	r, err := m.resource(resource)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.validate(record); err != nil {
+		return nil, err
+	}
+	if err = r.complete(record); err != nil {
+		return nil, err
+	}
+
+	if id, ok := record["id"]; ok && r.find(id) != nil {
+		return nil, &StorageError{Code: 409, Message: "resource already exists"}
+	}
+	if err = r.conflict(record); err != nil {
+		return nil, err
+	}
+	created := r.insert(record)
+
+	return &Response{Created: 1, Data: Records{copyRecord(created)}}, nil
+}
+
+//returns the record with the id, or nil if there is none
+func (r *memoryResource) find(id interface{}) Record {
+	for _, existing := range r.records {
+		if sameValue(existing["id"], id) {
+			return existing
+		}
+	}
+	return nil
+}
+
+//returns the record with the id should it meet the filters, or nil
+func (r *memoryResource) findMeeting(id interface{}, where []Filter) (Record, *StorageError) {
+	existing := r.find(id)
+	if existing == nil {
+		return nil, nil
+	}
+	if ok, err := meetsAll(existing, where); err != nil || !ok {
+		return nil, err
+	}
+	return existing, nil
+}
+
+//a 409 should the record hold a unique value another record has, the record sharing its id is the one it replaces
+func (r *memoryResource) conflict(record Record) *StorageError {
+	for _, c := range r.unique {
+		v := record[c]
+		if v == nil {
+			continue
+		}
+		for _, existing := range r.records {
+			if existing[c] != nil && sameValue(existing[c], v) && !sameValue(existing["id"], record["id"]) {
+				return &StorageError{Code: 409, Message: "resource already exists"}
+			}
+		}
+	}
+	return nil
+}
+
+//a 409 should the record share a unique value with a record given before it in the same bulk
+//claimed holds the unique values given so far
+func (r *memoryResource) claim(record Record, claimed map[string]bool) *StorageError {
+	for _, c := range r.unique {
+		v := record[c]
+		if v == nil {
+			continue
+		}
+		value := c + "\x00" + fmt.Sprint(v)
+		if claimed[value] {
+			return &StorageError{Code: 409, Message: "resource already exists"}
+		}
+		claimed[value] = true
+	}
+	return nil
+}
+
+//appends and returns a copy of the record, assigning the next id when it has none
+//like an auto increment column a larger numeric id moves the next one past it
+func (r *memoryResource) insert(record Record) Record {
+	created := copyRecord(record)
+	if id, ok := created["id"]; ok {
+		if n, e := strconv.ParseInt(fmt.Sprint(id), 10, 64); e == nil && n >= r.nextId {
+			r.nextId = n + 1
+		}
+	} else {
+		created["id"] = r.nextId
+		r.nextId++
+	}
+	r.records = append(r.records, created)
+	return created
+}
+
+//checks the record holds every column required to create it
+func (r *memoryResource) complete(record Record) *StorageError {
+	for _, c := range r.required {
+		if _, ok := record[c]; !ok {
+			return &StorageError{Code: 400, Message: "resource does not include all required values"}
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) Read(ctx context.Context, resource Resource, query *Query) (*Response, *StorageError) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	r, err := m.resource(resource)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range query.Filters {
+		if !r.hasColumn(f.Column) {
+			return nil, &StorageError{Code: 400, Message: fmt.Sprintf("unknown field '%s'", f.Column)}
+		}
+	}
+
+	for _, f := range query.Fields {
+		if !r.hasColumn(f) {
+			return nil, &StorageError{Code: 400, Message: fmt.Sprintf("unknown field '%s'", f)}
+		}
+	}
+	for _, k := range query.Sort {
+		if !r.hasColumn(k.Column) {
+			return nil, &StorageError{Code: 400, Message: fmt.Sprintf("unknown field '%s'", k.Column)}
+		}
+	}
+
+	if query.After != nil && len(query.After) != len(query.Sort) {
+		return nil, &StorageError{Code: 400, Message: "the cursor does not match the sort"}
+	}
+
+	records := r.records
+	if len(query.Sort) > 0 {
+		records = sortRecords(records, query.Sort)
+	}
+
+	tableData := Records{}
+	offset := query.Offset
+	var total int64
+	for _, record := range records {
+		ok, err := meetsAll(record, query.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		total++
+		if query.After != nil && compareSortValues(sortValues(record, query.Sort), query.After, query.Sort) <= 0 {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(tableData) < query.Limit {
+			tableData = append(tableData, project(record, query.Fields))
+		}
+	}
+
+	result := Response{Data: tableData}
+	if query.Count {
+		result.Meta = &Meta{Total: total}
+	}
+	return &result, nil
+}
+
+func (m *MemoryStorage) Update(ctx context.Context, resource Resource, record Record, where ...Filter) (*Response, *StorageError) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	r, err := m.resource(resource)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.validate(record); err != nil {
+		return nil, err
+	}
+	if _, err = r.keyOf(record); err != nil {
+		return nil, err
+	}
+	if len(record) == len(r.Key) {
+		return nil, &StorageError{Code: 400, Message: "no fields to update"}
+	}
+	existing, err := r.findMeeting(record["id"], where)
+	if err != nil || existing == nil {
+		return &Response{}, err
+	}
+	if err = r.conflict(record); err != nil {
+		return nil, err
+	}
+
+	for k, v := range record {
+		if k != "id" {
+			existing[k] = v
+		}
+	}
+	return &Response{Updated: 1}, nil
+}
+
+func (m *MemoryStorage) Upsert(ctx context.Context, resource Resource, record Record, where ...Filter) (*Response, *StorageError) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	r, err := m.resource(resource)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := record["id"]; !ok {
+		return nil, &StorageError{Code: 400, Message: "an upsert requires a record id"}
+	}
+	if err = r.validate(record); err != nil {
+		return nil, err
+	}
+
+	existing := r.find(record["id"])
+	if existing != nil {
+		if ok, err := meetsAll(existing, where); err != nil || !ok {
+			if err == nil {
+				err = &StorageError{Code: 404, Message: "record not found"}
+			}
+			return nil, err
+		}
+	} else if err = r.complete(record); err != nil {
+		return nil, err
+	}
+	if err = r.conflict(record); err != nil {
+		return nil, err
+	}
+
+	if existing != nil {
+		for k, v := range record {
+			if k != "id" {
+				existing[k] = v
+			}
+		}
+		return &Response{Updated: 1}, nil
+	}
+	created := r.insert(record)
+	return &Response{Created: 1, Data: Records{copyRecord(created)}}, nil
+}
+
+func (m *MemoryStorage) Delete(ctx context.Context, resource Resource, record Record, where ...Filter) (*Response, *StorageError) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	r, err := m.resource(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := r.findMeeting(record["id"], where)
+	if err != nil || existing == nil {
+		return &Response{}, err
+	}
+
+	kept := Records{}
+	for _, other := range r.records {
+		if !sameValue(other["id"], record["id"]) {
+			kept = append(kept, other)
+		}
+	}
+	r.records = kept
+	return &Response{Deleted: 1}, nil
+}
+
+//validates every record before creating any, so a bulk is created whole or not at all
+func (m *MemoryStorage) BulkCreate(ctx context.Context, resource Resource, records Records) (*Response, *StorageError) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	r, err := m.resource(resource)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, &StorageError{Code: 400, Message: "no records given"}
+	}
+	seen := map[string]bool{}
+	claimed := map[string]bool{}
+	for i, record := range records {
+		if err = r.validate(record); err == nil {
+			err = r.complete(record)
+		}
+		if err != nil {
+			return nil, itemError(i, err)
+		}
+		if id, ok := record["id"]; ok {
+			if r.find(id) != nil || seen[fmt.Sprint(id)] {
+				return nil, itemError(i, &StorageError{Code: 409, Message: "resource already exists"})
+			}
+			seen[fmt.Sprint(id)] = true
+		}
+		if err = r.conflict(record); err == nil {
+			err = r.claim(record, claimed)
+		}
+		if err != nil {
+			return nil, itemError(i, err)
+		}
+	}
+
+	result := Response{Created: int64(len(records))}
+	for _, record := range records {
+		created := r.insert(record)
+		result.Data = append(result.Data, copyRecord(created))
+		result.Items = append(result.Items, Item{Status: 201, Id: created["id"]})
+	}
+	return &result, nil
+}
+
+func (m *MemoryStorage) BulkUpdate(ctx context.Context, resource Resource, records Records, where ...Filter) (*Response, *StorageError) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	r, err := m.resource(resource)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, &StorageError{Code: 400, Message: "no records given"}
+	}
+	seen := map[string]bool{}
+	claimed := map[string]bool{}
+	for i, record := range records {
+		id, ok := record["id"]
+		if !ok {
+			return nil, itemError(i, &StorageError{Code: 400, Message: "an update requires a record id"})
+		}
+		if seen[fmt.Sprint(id)] {
+			return nil, itemError(i, &StorageError{Code: 400, Message: fmt.Sprintf("id '%v' is given more than once", id)})
+		}
+		seen[fmt.Sprint(id)] = true
+		if err = r.validate(record); err != nil {
+			return nil, itemError(i, err)
+		}
+		if existing, _ := r.findMeeting(id, where); existing != nil {
+			if err = r.conflict(record); err == nil {
+				err = r.claim(record, claimed)
+			}
+			if err != nil {
+				return nil, itemError(i, err)
+			}
+		}
+	}
+
+	result := Response{}
+	for _, record := range records {
+		existing, err := r.findMeeting(record["id"], where)
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil {
+			result.Items = append(result.Items, Item{Status: 404, Id: record["id"], Message: "record not found"})
+			continue
+		}
+		for k, v := range record {
+			if k != "id" {
+				existing[k] = v
+			}
+		}
+		result.Updated++
+		result.Items = append(result.Items, Item{Status: 200, Id: record["id"]})
+	}
+	return &result, nil
+}
+
+func (m *MemoryStorage) BulkDelete(ctx context.Context, resource Resource, query *Query) (*Response, *StorageError) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	r, err := m.resource(resource)
+	if err != nil {
+		return nil, err
+	}
+	if len(query.Filters) == 0 {
+		return nil, &StorageError{Code: 400, Message: "a bulk delete requires a filter"}
+	}
+	for _, f := range query.Filters {
+		if !r.hasColumn(f.Column) {
+			return nil, &StorageError{Code: 400, Message: fmt.Sprintf("unknown field '%s'", f.Column)}
+		}
+	}
+
+	result := Response{}
+	kept := Records{}
+	for _, existing := range r.records {
+		ok, err := meetsAll(existing, query.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			result.Deleted++
+			result.Items = append(result.Items, Item{Status: 200, Id: existing["id"]})
+			continue
+		}
+		kept = append(kept, existing)
+	}
+	r.records = kept
+	return &result, nil
+}
+
+//a transaction over a memory storage, it works on a copy of the records that replaces the originals on commit
+//the storage stays locked until the transaction ends, so transactions and writes to it wait their turn
+type memoryTx struct {
+	*MemoryStorage
+	parent *MemoryStorage
+	done   bool
+}
+
+//ctx can't interrupt a memory transaction, it is only checked before starting one
+func (m *MemoryStorage) Begin(ctx context.Context) (Tx, *StorageError) {
+	if err := contextError(ctx); err != nil {
+		return nil, err
+	}
+	m.lock.Lock()
+	scoped := NewMemoryStorage()
+	for name, r := range m.resources {
+		records := Records{}
+		for _, record := range r.records {
+			records = append(records, copyRecord(record))
+		}
+		scoped.resources[name] = &memoryResource{Table: r.Table, required: r.required, unique: r.unique, records: records, nextId: r.nextId}
+	}
+	return &memoryTx{MemoryStorage: scoped, parent: m}, nil
+}
+
+func (t *memoryTx) Begin(ctx context.Context) (Tx, *StorageError) {
+	return nil, &StorageError{Code: 500, Message: "transactions can't be nested"}
+}
+
+func (t *memoryTx) Commit() *StorageError {
+	if t.done {
+		return &StorageError{Code: 500, Message: "the transaction has already ended"}
+	}
+	t.done = true
+	t.parent.resources = t.resources
+	t.parent.lock.Unlock()
+	return nil
+}
+
+func (t *memoryTx) Rollback() *StorageError {
+	if t.done {
+		return &StorageError{Code: 500, Message: "the transaction has already ended"}
+	}
+	t.done = true
+	t.parent.lock.Unlock()
+	return nil
+}
+
+//rolls back unless the transaction was committed
+func (t *memoryTx) Close() error {
+	if !t.done {
+		t.Rollback()
+	}
+	return nil
+}
+
+//memory storage holds no connections, there is nothing to release
+func (m *MemoryStorage) Close() error {
+	return nil
+}
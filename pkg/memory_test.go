@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestMemoryStorage(rows int) *MemoryStorage {
+	m := NewMemoryStorage()
+	m.AddResource(Resource{"veil_test_resource"}, []string{"test_field_1", "test_field_2"}, []string{"test_field_1", "test_field_2"})
+	for i := 0; i < rows; i++ {
+		m.Create(context.Background(), Resource{"veil_test_resource"}, Record{"test_field_1": fmt.Sprintf("test_value_%d", i), "test_field_2": "test value"})
+	}
+	return m
+}
+
+func TestMemoryStorage(t *testing.T) {
+	m := newTestMemoryStorage(5)
+	resource := Resource{"veil_test_resource"}
+
+	r, err := m.Read(context.Background(), resource, MatchQuery(Record{}, 0, 10))
+	if err != nil || len(r.Data) != 5 || r.Data[0]["id"] != int64(1) {
+		t.Fatalf("expected 5 records with auto incremented ids, got %v %v", r, err)
+	}
+
+	r, _ = m.Read(context.Background(), resource, MatchQuery(Record{}, 3, 10))
+	if len(r.Data) != 2 || r.Data[0]["id"] != int64(4) {
+		t.Errorf("offset not honoured, got %v", r.Data)
+	}
+
+	r, _ = m.Read(context.Background(), resource, MatchQuery(Record{}, 1, 2))
+	if len(r.Data) != 2 || r.Data[1]["id"] != int64(3) {
+		t.Errorf("limit not honoured, got %v", r.Data)
+	}
+
+	r, _ = m.Read(context.Background(), resource, MatchQuery(Record{"id": "2", "test_field_1": "test_value_1"}, 0, 10))
+	if len(r.Data) != 1 {
+		t.Errorf("match not honoured, got %v", r.Data)
+	}
+
+	if _, err = m.Read(context.Background(), Resource{"veil_test_not_exist"}, &Query{Limit: 10}); err == nil || err.Code != 404 {
+		t.Errorf("expected a 404 for an unknown resource, got %v", err)
+	}
+
+	if _, err = m.Create(context.Background(), resource, Record{"test_field_1": "t"}); err == nil || err.Code != 400 {
+		t.Errorf("expected a 400 for a missing required field, got %v", err)
+	}
+
+	if _, err = m.Create(context.Background(), resource, Record{"test_field_1": "t", "test_field_2": "t", "nope": "t"}); err == nil || err.Code != 400 {
+		t.Errorf("expected a 400 for an unknown field, got %v", err)
+	}
+
+	u, err := m.Update(context.Background(), resource, Record{"id": "1", "test_field_1": "123"})
+	if err != nil || u.Updated != 1 {
+		t.Errorf("expected 1 update, got %v %v", u, err)
+	}
+	r, _ = m.Read(context.Background(), resource, MatchQuery(Record{"id": 1}, 0, 1))
+	if r.Data[0]["test_field_1"] != "123" {
+		t.Errorf("record not properly updated, got %v", r.Data)
+	}
+
+	d, err := m.Delete(context.Background(), resource, Record{"id": "1"})
+	if err != nil || d.Deleted != 1 {
+		t.Errorf("expected 1 delete, got %v %v", d, err)
+	}
+	d, _ = m.Delete(context.Background(), resource, Record{"id": "1"})
+	if d.Deleted != 0 {
+		t.Errorf("expected nothing to delete, got %v", d)
+	}
+}
+
+func TestMemoryStorageHandler(t *testing.T) {
+	m := newTestMemoryStorage(2)
+	Config().PutPermissions = map[string]string{"global": "allow"}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Handler(w, r, m)
+	}))
+	defer ts.Close()
+
+	res := request("PUT", ts.URL+"/veil_test_resource", "{\"test_field_1\":\"fgfg\", \"test_field_2\":\"fgfg\"}")
+	if res.StatusCode != 201 {
+		t.Fatalf("PUT expected a 201, got %d", res.StatusCode)
+	}
+
+	res = request("GET", ts.URL+"/veil_test_resource/3", "")
+	j := loadResponseBody(res)
+	if res.StatusCode != 200 || len(j.Data) != 1 || j.Data[0]["test_field_1"] != "fgfg" {
+		t.Errorf("GET expected the created record, got %d %v", res.StatusCode, j.Data)
+	}
+
+	res = request("GET", ts.URL+"/veil_test_not_exist", "")
+	if res.StatusCode != 404 {
+		t.Errorf("GET expected a 404, got %d", res.StatusCode)
+	}
+}
@@ -10,6 +10,8 @@ type Context struct {
 	Parameters map[string]string   //any request parameters to apply
 	Config     *Configuration      //our configuration obj
 	Response   *Response           //our response
+	Identity   *Identity           //the authenticated caller, nil when anonymous
+	Storage    Storage             //the storage serving the request
 }
 
 //sets our access control headers
@@ -17,28 +19,33 @@ func AccessHeaders(c *Context) {
 	c.Write.Header().Set("Access-Control-Allow-Origin", "*")
 }
 
-//our filter to checck permissions
+//the permission given to the resource, a resource without its own falls back to the global one
+func permission(permissions map[string]string, resource string) string {
+	if p, ok := permissions[resource]; ok {
+		return p
+	}
+	return permissions["global"]
+}
+
+//our filter to check permissions, each method has its own rules and each resource may override the global rule
 func Permissions(c *Context) {
+	var permissions map[string]string
 	switch c.Req.Method {
 	case "GET":
-		if c.Config.GetPermissions["global"] != "allow" {
-			c.Continue = false
-		}
+		permissions = c.Config.GetPermissions
 	case "PUT":
-		if c.Config.PutPermissions["global"] != "allow" {
-			c.Continue = false
-		}
+		permissions = c.Config.PutPermissions
 	case "POST":
-		if c.Config.PostPermissions["global"] != "allow" {
-			c.Continue = false
-		}
+		permissions = c.Config.PostPermissions
+	case "PATCH":
+		permissions = c.Config.PatchPermissions
 	case "DELETE":
-		if c.Config.PostPermissions["global"] != "allow" {
-			c.Continue = false
-		}
+		permissions = c.Config.DeletePermissions
+	default:
+		return
 	}
-	if c.Continue == false {
+	if permission(permissions, requestedResource(c.Req)) != "allow" {
+		c.Continue = false
 		MessageResponse(c.Write, 401, "Permission denied")
 	}
 }
-
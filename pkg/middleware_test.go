@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPermissions(t *testing.T) {
+	conf := &Configuration{
+		GetPermissions:    parsePermissionConf("global:deny;products:allow"),
+		PutPermissions:    parsePermissionConf("global:allow;users:deny"),
+		PostPermissions:   parsePermissionConf("global:deny"),
+		PatchPermissions:  parsePermissionConf("products:allow"),
+		DeletePermissions: parsePermissionConf("global:allow"),
+	}
+	cases := []struct {
+		method string
+		path   string
+		allow  bool
+	}{
+		{"GET", "/products", true},
+		{"GET", "/products/1", true},
+		{"GET", "/users", false},
+		{"PUT", "/products", true},
+		{"PUT", "/users/1", false},
+		{"POST", "/products/1", false},
+		{"PATCH", "/products/1", true},
+		{"PATCH", "/users/1", false},
+		{"DELETE", "/users/1", true},
+		{"OPTIONS", "/users", true},
+		{"GET", "/products/users/1", false},
+		{"GET", "http://example.com", false},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		con := Context{Continue: true, Req: httptest.NewRequest(c.method, c.path, nil), Write: w, Config: conf}
+		Permissions(&con)
+		if con.Continue != c.allow {
+			t.Errorf("%s %s expected allowed %v, got %v", c.method, c.path, c.allow, con.Continue)
+		}
+		if !c.allow && w.Code != 401 {
+			t.Errorf("%s %s expected a 401, got %d", c.method, c.path, w.Code)
+		}
+	}
+}
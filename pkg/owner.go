@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"net/http"
+)
+
+//binds a request to the rows of a resource its caller owns, by the resource's owner column
+type ownerBinding struct {
+	Column string //the column holding the owner of each row
+	Owner  string //the caller, as the subject of their identity
+}
+
+//binds the request to the caller's rows of the resource
+//nil is returned when the resource has no owner column or the caller holds the admin role, they see every row
+//callers without a subject own nothing, so they are refused with a 401
+func bindOwner(r *http.Request, resource Resource) (*ownerBinding, *StorageError) {
+	column, ok := Config().OwnerColumns[resource.Identifier]
+	if !ok {
+		return nil, nil
+	}
+	identity := IdentityFrom(r.Context())
+	if identity == nil || identity.Subject == "" {
+		return nil, &StorageError{Code: 401, Message: "authentication required"}
+	}
+	if contains(identity.Roles, Config().AdminRole) {
+		return nil, nil
+	}
+	return &ownerBinding{Column: column, Owner: identity.Subject}, nil
+}
+
+//sets the owner column of the record to the caller, so a caller can neither write rows for another nor give theirs away
+func (b *ownerBinding) own(record Record) {
+	if b != nil {
+		record[b.Column] = b.Owner
+	}
+}
+
+//the filter matching the caller's rows
+func (b *ownerBinding) filter() Filter {
+	return Filter{Column: b.Column, Operator: Eq, Value: b.Owner}
+}
+
+//the filters narrowing a write to the caller's rows, so a row can't change hands between a check and the write
+//none when the request isn't bound
+func (b *ownerBinding) where() []Filter {
+	if b == nil {
+		return nil
+	}
+	return []Filter{b.filter()}
+}
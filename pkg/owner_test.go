@@ -0,0 +1,160 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOwnerColumn(t *testing.T) {
+	notes := Resource{"veil_test_notes"}
+	m := NewMemoryStorage()
+	m.AddResource(notes, []string{"user_id", "body"}, []string{"user_id"})
+	m.Create(context.Background(), notes, Record{"user_id": "jo", "body": "mine"})
+	m.Create(context.Background(), notes, Record{"user_id": "al", "body": "theirs"})
+
+	conf := Config()
+	defer func(owners map[string]string, put, post, patch, del map[string]string) {
+		conf.OwnerColumns, conf.PutPermissions, conf.PostPermissions, conf.PatchPermissions, conf.DeletePermissions = owners, put, post, patch, del
+	}(conf.OwnerColumns, conf.PutPermissions, conf.PostPermissions, conf.PatchPermissions, conf.DeletePermissions)
+	conf.OwnerColumns = map[string]string{notes.Identifier: "user_id"}
+	allow := map[string]string{"global": "allow"}
+	conf.PutPermissions, conf.PostPermissions, conf.PatchPermissions, conf.DeletePermissions = allow, allow, allow, allow
+
+	//callers are named by a header, standing in for an authenticated identity
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if caller := r.Header.Get("X-Test-Caller"); caller != "" {
+			identity := Identity{Subject: caller}
+			if caller == "root" {
+				identity.Roles = []string{conf.AdminRole}
+			}
+			r = r.WithContext(WithIdentity(r.Context(), &identity))
+		}
+		Handler(w, r, m)
+	}))
+	defer ts.Close()
+
+	call := func(caller string, method string, path string, body string) (int, Response) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		if caller != "" {
+			req.Header.Set("X-Test-Caller", caller)
+		}
+		if method == "PATCH" {
+			req.Header.Set("Content-Type", MergePatchContentType)
+		}
+		res, e := http.DefaultClient.Do(req)
+		if e != nil {
+			t.Fatal(e)
+		}
+		return res.StatusCode, loadResponseBody(res)
+	}
+
+	if status, j := call("jo", "GET", "/veil_test_notes", ""); status != 200 || len(j.Data) != 1 || j.Data[0]["body"] != "mine" {
+		t.Errorf("GET expected only the caller's rows, got %d %v", status, j.Data)
+	}
+	if status, _ := call("jo", "GET", "/veil_test_notes/2", ""); status != 404 {
+		t.Errorf("GET of another's row expected a 404, got %d", status)
+	}
+	if status, j := call("root", "GET", "/veil_test_notes", ""); status != 200 || len(j.Data) != 2 {
+		t.Errorf("GET by an admin expected every row, got %d %v", status, j.Data)
+	}
+	if status, _ := call("", "GET", "/veil_test_notes", ""); status != 401 {
+		t.Errorf("GET by an anonymous caller expected a 401, got %d", status)
+	}
+
+	if status, j := call("jo", "PUT", "/veil_test_notes", `{"user_id":"al","body":"forged"}`); status != 201 || j.Data[0]["user_id"] != "jo" {
+		t.Errorf("PUT expected the row owned by the caller, got %d %v", status, j.Data)
+	}
+	if status, _ := call("jo", "PUT", "/veil_test_notes/2", `{"body":"taken"}`); status != 404 {
+		t.Errorf("PUT over another's row expected a 404, got %d", status)
+	}
+
+	if status, _ := call("jo", "POST", "/veil_test_notes/2", `{"body":"changed"}`); status != 404 {
+		t.Errorf("POST to another's row expected a 404, got %d", status)
+	}
+	if status, j := call("jo", "POST", "/veil_test_notes/1", `{"user_id":"al"}`); status != 200 || j.Updated != 1 {
+		t.Errorf("POST to the caller's row expected a 200, got %d", status)
+	}
+	if status, _ := call("jo", "PATCH", "/veil_test_notes/2", `{"body":"changed"}`); status != 404 {
+		t.Errorf("PATCH of another's row expected a 404, got %d", status)
+	}
+	status, j := call("jo", "POST", "/veil_test_notes", `[{"id":1,"body":"bulk"},{"id":2,"body":"bulk"}]`)
+	if status != 200 || j.Updated != 1 || len(j.Items) != 2 || j.Items[0].Status != 200 || j.Items[1].Status != 404 {
+		t.Errorf("bulk POST expected only the caller's row updated, got %d %v", status, j)
+	}
+
+	if status, _ := call("jo", "DELETE", "/veil_test_notes/2", ""); status != 404 {
+		t.Errorf("DELETE of another's row expected a 404, got %d", status)
+	}
+	if status, j := call("jo", "DELETE", "/veil_test_notes?body[in]=bulk,theirs,forged", ""); status != 200 || j.Deleted != 2 {
+		t.Errorf("bulk DELETE expected only the caller's rows deleted, got %d %v", status, j)
+	}
+	if status, j := call("root", "GET", "/veil_test_notes", ""); status != 200 || len(j.Data) != 1 || j.Data[0]["user_id"] != "al" || j.Data[0]["body"] != "theirs" {
+		t.Errorf("another's row expected untouched, got %d %v", status, j.Data)
+	}
+
+	//a longer path can't write the resource without its owner being bound
+	for _, c := range []struct {
+		method string
+		path   string
+		body   string
+	}{
+		{"POST", "/x/veil_test_notes/2", `{"body":"changed"}`},
+		{"PUT", "/x/y/veil_test_notes", `{"user_id":"al","body":"forged"}`},
+		{"DELETE", "/x/veil_test_notes/2", ""},
+	} {
+		if status, _ := call("jo", c.method, c.path, c.body); status != 404 {
+			t.Errorf("%s %s expected a 404, got %d", c.method, c.path, status)
+		}
+	}
+	if status, j := call("root", "GET", "/veil_test_notes", ""); status != 200 || len(j.Data) != 1 || j.Data[0]["body"] != "theirs" {
+		t.Errorf("a longer path expected to write nothing, got %d %v", status, j.Data)
+	}
+}
+
+//a storage whose reads find nothing, as though every row appeared just after the handler looked
+type blindStorage struct {
+	*MemoryStorage
+}
+
+func (s blindStorage) Read(ctx context.Context, resource Resource, query *Query) (*Response, *StorageError) {
+	return &Response{Data: Records{}}, nil
+}
+
+func TestOwnerColumnWrites(t *testing.T) {
+	notes := Resource{"veil_test_notes"}
+	m := NewMemoryStorage()
+	m.AddResource(notes, []string{"user_id", "body"}, []string{"user_id"})
+	m.Create(context.Background(), notes, Record{"user_id": "al", "body": "theirs"})
+
+	conf := Config()
+	defer func(owners map[string]string, put, post, del map[string]string) {
+		conf.OwnerColumns, conf.PutPermissions, conf.PostPermissions, conf.DeletePermissions = owners, put, post, del
+	}(conf.OwnerColumns, conf.PutPermissions, conf.PostPermissions, conf.DeletePermissions)
+	conf.OwnerColumns = map[string]string{notes.Identifier: "user_id"}
+	allow := map[string]string{"global": "allow"}
+	conf.PutPermissions, conf.PostPermissions, conf.DeletePermissions = allow, allow, allow
+
+	//the owner is checked by the write itself, so a row the handler couldn't see is still refused
+	storage := blindStorage{m}
+	for _, c := range []struct {
+		method string
+		body   string
+	}{
+		{"PUT", `{"body":"taken"}`},
+		{"POST", `{"body":"changed"}`},
+		{"DELETE", ""},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(c.method, "/veil_test_notes/1", strings.NewReader(c.body))
+		Handler(w, r.WithContext(WithIdentity(r.Context(), &Identity{Subject: "jo"})), storage)
+		if w.Code != 404 {
+			t.Errorf("%s of another's row expected a 404, got %d", c.method, w.Code)
+		}
+	}
+	if result, _ := m.Read(context.Background(), notes, &Query{Limit: 10}); len(result.Data) != 1 || result.Data[0]["user_id"] != "al" || result.Data[0]["body"] != "theirs" {
+		t.Errorf("another's row expected untouched, got %v", result.Data)
+	}
+}
@@ -0,0 +1,146 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+//the content types PATCH accepts
+const (
+	MergePatchContentType = "application/merge-patch+json" //RFC 7396
+	JSONPatchContentType  = "application/json-patch+json"  //RFC 6902
+)
+
+//an error applying a patch, the code follows http status code conventions
+type patchError struct {
+	Code    int
+	Message string
+}
+
+func (e *patchError) Error() string {
+	return e.Message
+}
+
+//a single RFC 6902 operation
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"` //empty when the operation has none, null is a value
+}
+
+//parses a merge patch into the columns to change, null values set a column to NULL
+//records are flat so nested objects and arrays can't be merged
+func parseMergePatch(body []byte, key []string) (Record, *patchError) {
+	var patch map[string]interface{}
+	if e := json.Unmarshal(body, &patch); e != nil {
+		return nil, &patchError{400, "payload could not be parsed"}
+	}
+	changes := Record{}
+	for k, v := range patch {
+		if err := patchable(k, v, key); err != nil {
+			return nil, err
+		}
+		changes[k] = v
+	}
+	return changes, nil
+}
+
+//applies a json patch to the current record and returns the columns it changed
+func applyJSONPatch(current Record, body []byte, key []string) (Record, *patchError) {
+	var operations []patchOperation
+	if e := json.Unmarshal(body, &operations); e != nil {
+		return nil, &patchError{400, "payload could not be parsed"}
+	}
+
+	patched := copyRecord(current)
+	for i, o := range operations {
+		column, err := patchColumn(o.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if len(o.Value) > 0 {
+			if e := json.Unmarshal(o.Value, &value); e != nil {
+				return nil, &patchError{400, fmt.Sprintf("operation %d has an improper value", i)}
+			}
+		} else if o.Op == "add" || o.Op == "replace" || o.Op == "test" {
+			return nil, &patchError{400, fmt.Sprintf("operation %d is missing a value", i)}
+		}
+
+		switch o.Op {
+		case "add", "replace":
+			if _, ok := patched[column]; !ok && o.Op == "replace" {
+				return nil, &patchError{409, fmt.Sprintf("operation %d replaces unknown field '%s'", i, column)}
+			}
+			patched[column] = value
+		case "remove":
+			//a column can't be removed from a row, so it is set to NULL
+			if _, ok := patched[column]; !ok {
+				return nil, &patchError{409, fmt.Sprintf("operation %d removes unknown field '%s'", i, column)}
+			}
+			patched[column] = nil
+		case "move", "copy":
+			from, err := patchColumn(o.From)
+			if err != nil {
+				return nil, err
+			}
+			v, ok := patched[from]
+			if !ok {
+				return nil, &patchError{409, fmt.Sprintf("operation %d reads unknown field '%s'", i, from)}
+			}
+			if o.Op == "move" {
+				patched[from] = nil
+			}
+			patched[column] = v
+		case "test":
+			if v, ok := patched[column]; !ok || !sameJSONValue(v, value) {
+				return nil, &patchError{409, fmt.Sprintf("operation %d failed its test of '%s'", i, column)}
+			}
+		default:
+			return nil, &patchError{400, fmt.Sprintf("operation %d has unknown op '%s'", i, o.Op)}
+		}
+	}
+
+	changes := Record{}
+	for k, v := range patched {
+		if old, ok := current[k]; ok && sameJSONValue(old, v) {
+			continue
+		}
+		if err := patchable(k, v, key); err != nil {
+			return nil, err
+		}
+		changes[k] = v
+	}
+	return changes, nil
+}
+
+//the column a json pointer refers to, records are flat so only top level pointers are allowed
+func patchColumn(pointer string) (string, *patchError) {
+	if !strings.HasPrefix(pointer, "/") || strings.Count(pointer, "/") != 1 {
+		return "", &patchError{400, fmt.Sprintf("improper path '%s'", pointer)}
+	}
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(pointer[1:]), nil
+}
+
+//checks a column may be set to the value, the key columns address the record so they can't be
+func patchable(column string, value interface{}, key []string) *patchError {
+	if contains(key, column) {
+		return &patchError{400, fmt.Sprintf("the key '%s' can't be patched", column)}
+	}
+	switch value.(type) {
+	case map[string]interface{}, []interface{}:
+		return &patchError{400, fmt.Sprintf("nested values are not supported for '%s'", column)}
+	}
+	return nil
+}
+
+//compares values as json would see them, so a stored 30 equals a patched 30.0
+func sameJSONValue(a interface{}, b interface{}) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return sameValue(a, b)
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"testing"
+)
+
+func TestParseMergePatch(t *testing.T) {
+	changes, err := parseMergePatch([]byte(`{"name": "jo", "deleted_at": null}`), []string{"id"})
+	if err != nil || len(changes) != 2 || changes["name"] != "jo" {
+		t.Errorf("unexpected changes %v %v", changes, err)
+	}
+	if v, ok := changes["deleted_at"]; !ok || v != nil {
+		t.Errorf("expected null to set deleted_at to NULL, got %v", changes)
+	}
+
+	if _, err = parseMergePatch([]byte(`{"id": 2}`), []string{"id"}); err == nil || err.Code != 400 {
+		t.Errorf("expected patching the id to be a 400, got %v", err)
+	}
+	if _, err = parseMergePatch([]byte(`{"address": {"city": "x"}}`), []string{"id"}); err == nil || err.Code != 400 {
+		t.Errorf("expected a nested value to be a 400, got %v", err)
+	}
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	current := Record{"id": int64(1), "name": "jo", "age": int64(30), "nickname": "j", "a/b": "x"}
+
+	changes, err := applyJSONPatch(current, []byte(`[
+		{"op": "test", "path": "/age", "value": 30},
+		{"op": "replace", "path": "/name", "value": "joe"},
+		{"op": "remove", "path": "/nickname"},
+		{"op": "move", "from": "/a~1b", "path": "/name"},
+		{"op": "add", "path": "/age", "value": 30}
+	]`), []string{"id"})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(changes) != 3 || changes["name"] != "x" || changes["nickname"] != nil || changes["a/b"] != nil {
+		t.Errorf("unexpected changes %v", changes)
+	}
+	if current["name"] != "jo" {
+		t.Errorf("the current record was modified")
+	}
+
+	//null is a value like any other, it sets a column to NULL
+	changes, err = applyJSONPatch(current, []byte(`[
+		{"op": "replace", "path": "/name", "value": null},
+		{"op": "test", "path": "/name", "value": null},
+		{"op": "add", "path": "/age", "value": null}
+	]`), []string{"id"})
+	if err != nil {
+		t.Fatalf("unexpected error for null values %v", err)
+	}
+	if v, ok := changes["name"]; len(changes) != 2 || !ok || v != nil || changes["age"] != nil {
+		t.Errorf("expected null to set name and age to NULL, got %v", changes)
+	}
+
+	for patch, code := range map[string]int{
+		`[{"op": "test", "path": "/age", "value": 31}]`:            409,
+		`[{"op": "replace", "path": "/missing", "value": 1}]`:      409,
+		`[{"op": "replace", "path": "/id", "value": 2}]`:           400,
+		`[{"op": "replace", "path": "/name/first", "value": "j"}]`: 400,
+		`[{"op": "shuffle", "path": "/name"}]`:                     400,
+		`[{"op": "add", "path": "/name"}]`:                         400,
+		`[{"op": "test", "path": "/name", "value": null}]`:         409,
+		`{"op": "add"}`:                                            400,
+	} {
+		if _, err := applyJSONPatch(current, []byte(patch), []string{"id"}); err == nil || err.Code != code {
+			t.Errorf("expected %d for %s, got %v", code, patch, err)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+)
+
+//the role of callers who haven't authenticated
+const AnonymousRole = "anonymous"
+
+//a rule of a role, allowing or denying methods on the resources matching its pattern
+type PolicyRule struct {
+	Resource string   `json:"resource"` //the resources covered, a pattern such as products, report_* or *
+	Methods  []string `json:"methods"`  //the methods covered, * covers every method
+	Effect   string   `json:"effect"`   //allow or deny, allow when empty
+}
+
+//the rules of each role, read from a json policy file
+//a request is allowed when a rule of one of the caller's roles allows it and none denies it
+type Policy struct {
+	path  string
+	lock  sync.RWMutex
+	roles map[string][]PolicyRule
+}
+
+//reads the policy file at path
+//{"roles": {"editor": [{"resource": "products", "methods": ["GET", "PUT"]}, {"resource": "*", "methods": ["DELETE"], "effect": "deny"}]}}
+func LoadPolicy(path string) (*Policy, error) {
+	p := Policy{path: path}
+	if e := p.Reload(); e != nil {
+		return nil, e
+	}
+	return &p, nil
+}
+
+//reads the policy file again, should it be improper the rules in use are kept
+func (p *Policy) Reload() error {
+	b, e := ioutil.ReadFile(p.path)
+	if e != nil {
+		return e
+	}
+	var file struct {
+		Roles map[string][]PolicyRule `json:"roles"`
+	}
+	if e = json.Unmarshal(b, &file); e != nil {
+		return e
+	}
+	for role, rules := range file.Roles {
+		for i, r := range rules {
+			if _, e := path.Match(r.Resource, ""); e != nil || r.Resource == "" {
+				return fmt.Errorf("rule %d of role '%s' has an improper resource '%s'", i, role, r.Resource)
+			}
+			if len(r.Methods) == 0 {
+				return fmt.Errorf("rule %d of role '%s' has no methods", i, role)
+			}
+			if r.Effect != "" && r.Effect != "allow" && r.Effect != "deny" {
+				return fmt.Errorf("rule %d of role '%s' has an improper effect '%s'", i, role, r.Effect)
+			}
+		}
+	}
+
+	p.lock.Lock()
+	p.roles = file.Roles
+	p.lock.Unlock()
+	return nil
+}
+
+//whether the rule covers the method on the resource
+func (r *PolicyRule) covers(method string, resource string) bool {
+	if matched, _ := path.Match(r.Resource, resource); !matched {
+		return false
+	}
+	for _, m := range r.Methods {
+		if m == "*" || strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+//whether the roles may use the method on the resource, a denying rule overrides any allowing one
+func (p *Policy) Allows(roles []string, method string, resource string) bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	allowed := false
+	for _, role := range roles {
+		for _, r := range p.roles[role] {
+			if !r.covers(method, resource) {
+				continue
+			}
+			if r.Effect == "deny" {
+				return false
+			}
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+//our filter to check the caller's roles against the policy file, it replaces Permissions once a policy is configured
+//callers who haven't authenticated hold the anonymous role alone
+func Authorize(c *Context) {
+	if c.Config.Policy == nil {
+		Permissions(c)
+		return
+	}
+	if c.Req.Method == "OPTIONS" {
+		return
+	}
+
+	roles := []string{AnonymousRole}
+	if c.Identity != nil {
+		roles = c.Identity.Roles
+	}
+	if c.Config.Policy.Allows(roles, c.Req.Method, requestedResource(c.Req)) {
+		return
+	}
+
+	c.Continue = false
+	if c.Identity == nil {
+		unauthorized(c, "Permission denied")
+	} else {
+		MessageResponse(c.Write, 403, "Permission denied")
+	}
+}
@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writePolicy(t *testing.T, path string, policy string) {
+	if e := ioutil.WriteFile(path, []byte(policy), 0600); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	dir, e := ioutil.TempDir("", "veil")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "policy.json")
+	writePolicy(t, file, `{"roles": {
+		"admin": [{"resource": "*", "methods": ["*"]}],
+		"editor": [
+			{"resource": "*", "methods": ["GET", "PUT", "POST"]},
+			{"resource": "users", "methods": ["PUT", "POST"], "effect": "deny"}
+		],
+		"reporter": [{"resource": "report_*", "methods": ["GET"]}],
+		"anonymous": [{"resource": "products", "methods": ["GET"]}]
+	}}`)
+
+	policy, e := LoadPolicy(file)
+	if e != nil {
+		t.Fatal(e)
+	}
+	cases := []struct {
+		roles    []string
+		method   string
+		resource string
+		allow    bool
+	}{
+		{[]string{"admin"}, "DELETE", "users", true},
+		{[]string{"editor"}, "PUT", "products", true},
+		{[]string{"editor"}, "PUT", "users", false},
+		{[]string{"editor", "admin"}, "PUT", "users", false},
+		{[]string{"editor"}, "DELETE", "products", false},
+		{[]string{"reporter"}, "GET", "report_sales", true},
+		{[]string{"reporter"}, "GET", "sales", false},
+		{[]string{"anonymous"}, "get", "products", true},
+		{[]string{"unknown"}, "GET", "products", false},
+		{nil, "GET", "products", false},
+	}
+	for _, c := range cases {
+		if allowed := policy.Allows(c.roles, c.method, c.resource); allowed != c.allow {
+			t.Errorf("%v %s %s expected allowed %v, got %v", c.roles, c.method, c.resource, c.allow, allowed)
+		}
+	}
+
+	writePolicy(t, file, `{"roles": {"editor": [{"resource": "[", "methods": ["GET"]}]}}`)
+	if e = policy.Reload(); e == nil {
+		t.Error("an improper pattern expected to be refused")
+	}
+	if !policy.Allows([]string{"admin"}, "DELETE", "users") {
+		t.Error("a failed reload expected the rules in use to be kept")
+	}
+	writePolicy(t, file, `{"roles": {"admin": [{"resource": "*", "methods": ["GET"]}]}}`)
+	if e = policy.Reload(); e != nil {
+		t.Fatal(e)
+	}
+	if policy.Allows([]string{"admin"}, "DELETE", "users") {
+		t.Error("a reload expected the new rules in use")
+	}
+
+	conf := &Configuration{Policy: policy}
+	for _, c := range []struct {
+		identity *Identity
+		method   string
+		status   int
+	}{
+		{&Identity{Roles: []string{"admin"}}, "GET", 200},
+		{&Identity{Roles: []string{"admin"}}, "DELETE", 403},
+		{nil, "GET", 401},
+		{nil, "OPTIONS", 200},
+	} {
+		w := httptest.NewRecorder()
+		con := Context{Continue: true, Req: httptest.NewRequest(c.method, "/users", nil), Write: w, Config: conf, Identity: c.identity}
+		Authorize(&con)
+		if con.Continue != (c.status == 200) || (c.status != 200 && w.Code != c.status) {
+			t.Errorf("%s by %+v expected a %d, got %d", c.method, c.identity, c.status, w.Code)
+		}
+	}
+
+	//a CONNECT request has no path, it is judged as addressing no resource
+	w := httptest.NewRecorder()
+	con := Context{Continue: true, Req: httptest.NewRequest("CONNECT", "example.com:443", nil), Write: w, Config: conf, Identity: &Identity{Roles: []string{"admin"}}}
+	Authorize(&con)
+	if con.Continue || w.Code != 403 {
+		t.Errorf("a request without a resource expected a 403, got %d", w.Code)
+	}
+
+	//a nested path is not judged by the resource it starts with
+	writePolicy(t, file, `{"roles": {"editor": [{"resource": "products", "methods": ["GET"]}]}}`)
+	if e = policy.Reload(); e != nil {
+		t.Fatal(e)
+	}
+	for path, allow := range map[string]bool{"/products/1": true, "/products/users/1": false, "/x/y/products": false} {
+		w := httptest.NewRecorder()
+		con := Context{Continue: true, Req: httptest.NewRequest("GET", path, nil), Write: w, Config: conf, Identity: &Identity{Roles: []string{"editor"}}}
+		Authorize(&con)
+		if con.Continue != allow || (!allow && w.Code != 403) {
+			t.Errorf("GET %s by an editor expected allowed %v, got %d", path, allow, w.Code)
+		}
+	}
+}
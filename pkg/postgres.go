@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+type PostgresStorage struct {
+	sqlStorage
+}
+
+//opens a connection pool to the postgres database, it should be closed when no longer needed
+func NewPostgresStorage(connectionString string) (*PostgresStorage, *StorageError) {
+	p := PostgresStorage{sqlStorage{ConnectionString: connectionString, driver: "postgres", dialect: postgresDialect{}}}
+	if err := p.open(); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+func (postgresDialect) interpretError(err error) *StorageError {
+	return interpretPostgresError(err)
+}
+
+func (postgresDialect) quote(identifier string) string {
+	return pq.QuoteIdentifier(identifier)
+}
+
+func (postgresDialect) loadSchema(db *sql.DB) (Schema, error) {
+	return querySchema(db, `SELECT c.table_name, c.column_name, COALESCE(k.ordinal_position, 0) FROM information_schema.columns c
+		LEFT JOIN information_schema.table_constraints t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+			AND t.constraint_type = 'PRIMARY KEY'
+		LEFT JOIN information_schema.key_column_usage k ON k.constraint_schema = t.constraint_schema AND k.constraint_name = t.constraint_name
+			AND k.table_name = c.table_name AND k.column_name = c.column_name
+		WHERE c.table_schema = current_schema() ORDER BY c.table_name, c.ordinal_position`)
+}
+
+func (postgresDialect) lockRows() string {
+	return " FOR UPDATE"
+}
+
+func (postgresDialect) returning(columns string) string {
+	return " RETURNING " + columns
+}
+
+//a sequence hands out its next value whatever was inserted, so it is moved past a key given explicitly
+//it is only ever moved forward, and keys without a sequence are left alone
+func (p postgresDialect) advanceKey(ctx context.Context, tx *sql.Tx, table *Table) error {
+	if len(table.Key) != 1 {
+		return nil
+	}
+	var sequence sql.NullString
+	if e := tx.QueryRowContext(ctx, "SELECT pg_get_serial_sequence($1, $2)", p.quote(table.Name), table.Key[0]).Scan(&sequence); e != nil || !sequence.Valid {
+		return e
+	}
+	_, e := tx.ExecContext(ctx, fmt.Sprintf("SELECT setval($1, k.largest) FROM (SELECT MAX(%s) AS largest FROM %s) k WHERE k.largest >= (SELECT last_value FROM %s)",
+		p.quote(table.Key[0]), p.quote(table.Name), sequence.String), sequence.String)
+	return e
+}
+
+//interprets a postgres error and returns it as a Storage Error
+func interpretPostgresError(err error) *StorageError {
+	if err != nil {
+		switch err.(type) {
+		case *pq.Error:
+			switch err.(*pq.Error).Code.Name() {
+			case "undefined_table":
+				return &StorageError{Code: 404, Message: "resource not found", WrapsError: err}
+			case "not_null_violation":
+				return &StorageError{Code: 400, Message: "resource does not include all required values", WrapsError: err}
+			case "unique_violation":
+				return &StorageError{Code: 409, Message: "resource already exists", WrapsError: err}
+			default:
+				return &StorageError{Code: 400, Message: "unknown error", WrapsError: err}
+			}
+
+		default:
+			return &StorageError{Code: 400, Message: "unknown error", WrapsError: err}
+		}
+	}
+	return nil
+}
+
+//postgres has no LastInsertId so the created key is read back with RETURNING
+func (p *PostgresStorage) Create(ctx context.Context, resource Resource, record Record) (*Response, *StorageError) {
+	table, err := p.table(resource)
+	if err != nil {
+		return nil, err
+	}
+	if len(table.Key) == 0 {
+		//there is no key to read the record back by
+		return p.sqlStorage.Create(ctx, resource, record)
+	}
+	statement, values, err := p.insertSql(table, record)
+	if err != nil {
+		return nil, err
+	}
+
+	statement += " RETURNING " + strings.Join(p.quoteAll(table.Key), ",")
+	stmt, err := p.prepare(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.release()
+
+	key := make([]interface{}, len(table.Key))
+	pointers := make([]interface{}, len(key))
+	for i := range key {
+		pointers[i] = &key[i]
+	}
+	e := stmt.QueryRowContext(ctx, values...).Scan(pointers...)
+
+	if err = p.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+	if _, keyErr := table.keyOf(record); keyErr == nil {
+		err = p.transaction(ctx, func(tx *sql.Tx) *StorageError {
+			return p.interpret(ctx, p.dialect.advanceKey(ctx, tx, table))
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	created := Record{}
+	for i, k := range table.Key {
+		if b, ok := key[i].([]byte); ok {
+			key[i] = string(b)
+		}
+		created[k] = key[i]
+	}
+	return p.created(ctx, resource, created)
+}
+
+func (p *PostgresStorage) Begin(ctx context.Context) (Tx, *StorageError) {
+	scoped := PostgresStorage{}
+	if err := p.begin(ctx, &scoped.sqlStorage); err != nil {
+		return nil, err
+	}
+	return &scoped, nil
+}
@@ -0,0 +1,32 @@
+package pkg
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestInterpretPostgresError(t *testing.T) {
+
+	if interpretPostgresError(nil) != nil {
+		t.Errorf("expected no error for nil")
+	}
+
+	cases := map[pq.ErrorCode]int{
+		"42P01": 404, //undefined_table
+		"23502": 400, //not_null_violation
+		"23505": 409, //unique_violation
+		"42601": 400, //syntax_error
+	}
+	for code, status := range cases {
+		err := interpretPostgresError(&pq.Error{Code: code})
+		if err == nil || err.Code != status {
+			t.Errorf("expected %d for sqlstate %s, got %v", status, code, err)
+		}
+	}
+
+	if err := interpretPostgresError(errors.New("boom")); err == nil || err.Code != 400 {
+		t.Errorf("expected a 400 for an unknown error, got %v", err)
+	}
+}
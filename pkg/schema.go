@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+//a table as described by the database, only tables in the schema are exposed as resources
+type Table struct {
+	Name    string   //the table name
+	Columns []string //the column names in their defined order
+	Key     []string //the primary key columns in order, empty when the table has none
+}
+
+//the tables of a database by name
+type Schema map[string]*Table
+
+//returns the table backing the resource, or a 404 if there is none
+func (s Schema) table(resource Resource) (*Table, *StorageError) {
+	t, ok := s[resource.Identifier]
+	if !ok {
+		return nil, &StorageError{Code: 404, Message: "resource not found"}
+	}
+	return t, nil
+}
+
+//adds a column to the named table, creating the table as needed
+func (s Schema) addColumn(table string, column string) {
+	t, ok := s[table]
+	if !ok {
+		t = &Table{Name: table}
+		s[table] = t
+	}
+	t.Columns = append(t.Columns, column)
+}
+
+func (t *Table) hasColumn(column string) bool {
+	for _, c := range t.Columns {
+		if c == column {
+			return true
+		}
+	}
+	return false
+}
+
+//checks every key of the record is a column of the table
+func (t *Table) validate(record Record) *StorageError {
+	for k := range record {
+		if !t.hasColumn(k) {
+			return &StorageError{Code: 400, Message: fmt.Sprintf("unknown field '%s'", k)}
+		}
+	}
+	return nil
+}
+
+//builds a schema from a query returning (table name, column name, primary key position) rows in column order
+//the position is 1 based, 0 for columns outside the primary key
+func querySchema(db *sql.DB, query string) (Schema, error) {
+	rows, e := db.Query(query)
+	if e != nil {
+		return nil, e
+	}
+	defer rows.Close()
+
+	schema := Schema{}
+	keys := map[string]map[int64]string{}
+	for rows.Next() {
+		var table, column string
+		var position int64
+		if e := rows.Scan(&table, &column, &position); e != nil {
+			return nil, e
+		}
+		schema.addColumn(table, column)
+		if position > 0 {
+			if keys[table] == nil {
+				keys[table] = map[int64]string{}
+			}
+			keys[table][position] = column
+		}
+	}
+	for table, columns := range keys {
+		for i := int64(1); i <= int64(len(columns)); i++ {
+			schema[table].Key = append(schema[table].Key, columns[i])
+		}
+	}
+	return schema, rows.Err()
+}
+
+//sets the primary keys of the tables named by the overrides, and falls back to an "id" column for tables without one
+func (s Schema) applyKeys(overrides map[string][]string) *StorageError {
+	for name, key := range overrides {
+		t, ok := s[name]
+		if !ok {
+			continue
+		}
+		for _, c := range key {
+			if !t.hasColumn(c) {
+				return &StorageError{Code: 500, Message: fmt.Sprintf("primary key column '%s' of '%s' does not exist", c, name)}
+			}
+		}
+		t.Key = key
+	}
+	for _, t := range s {
+		if len(t.Key) == 0 && t.hasColumn("id") {
+			t.Key = []string{"id"}
+		}
+	}
+	return nil
+}
+
+//whether the column is part of the primary key
+func (t *Table) isKey(column string) bool {
+	for _, k := range t.Key {
+		if k == column {
+			return true
+		}
+	}
+	return false
+}
+
+//the key columns of the record addressed by an escaped url segment, composite key values are separated by commas
+//values are unescaped once split, so a value holding a comma is written %2C
+func (t *Table) parseKey(segment string) (Record, *StorageError) {
+	if len(t.Key) == 0 {
+		return nil, &StorageError{Code: 400, Message: "resource has no primary key"}
+	}
+	values := []string{segment}
+	if len(t.Key) > 1 {
+		values = strings.Split(segment, ",")
+	}
+	if len(values) != len(t.Key) {
+		return nil, &StorageError{Code: 400, Message: fmt.Sprintf("expected a key of %s", strings.Join(t.Key, ","))}
+	}
+	key := Record{}
+	for i, k := range t.Key {
+		v, e := url.PathUnescape(values[i])
+		if e != nil {
+			return nil, &StorageError{Code: 400, Message: fmt.Sprintf("improper value for key '%s'", k), WrapsError: e}
+		}
+		key[k] = v
+	}
+	return key, nil
+}
+
+//the key columns of the record, or a 400 if it doesn't hold them all
+func (t *Table) keyOf(record Record) (Record, *StorageError) {
+	if len(t.Key) == 0 {
+		return nil, &StorageError{Code: 400, Message: "resource has no primary key"}
+	}
+	key := Record{}
+	for _, k := range t.Key {
+		v, ok := record[k]
+		if !ok {
+			return nil, &StorageError{Code: 400, Message: fmt.Sprintf("the record is missing its key '%s'", k)}
+		}
+		key[k] = v
+	}
+	return key, nil
+}
+
+//the record's key as it appears in a url, composite key values are separated by commas
+func (t *Table) keyPath(record Record) string {
+	var values []string
+	for _, k := range t.Key {
+		values = append(values, url.PathEscape(fmt.Sprint(record[k])))
+	}
+	return strings.Join(values, ",")
+}
+
+//identifies the record in bulk results, by the value of a single key column or the url form of a composite key
+func (t *Table) identify(record Record) interface{} {
+	if len(t.Key) == 1 {
+		return record[t.Key[0]]
+	}
+	return t.keyPath(record)
+}
+
+//whether two records have the same key, values are compared by their printed form
+func (t *Table) sameKey(a Record, b Record) bool {
+	for _, k := range t.Key {
+		if !sameValue(a[k], b[k]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,705 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//captures what differs between the sql databases veil can talk to
+type sqlDialect interface {
+	placeholder(n int) string                                       //returns the bind parameter for the nth (1 based) value of a statement
+	interpretError(err error) *StorageError                         //interprets a driver error and returns it as a Storage Error
+	quote(identifier string) string                                 //quotes a table or column name
+	loadSchema(db *sql.DB) (Schema, error)                          //reads the tables and columns of the connected database
+	lockRows() string                                               //the clause locking the rows a select reads until the transaction ends, empty when unsupported
+	returning(columns string) string                                //the clause returning the quoted columns of inserted rows, empty when unsupported
+	advanceKey(ctx context.Context, tx *sql.Tx, table *Table) error //moves the generator of the table's key past keys inserted explicitly
+}
+
+//implements Storage on top of database/sql, backends embed it and provide their driver and dialect
+type sqlStorage struct {
+	ConnectionString string     //our storage connection string
+	driver           string     //the database/sql driver name
+	dialect          sqlDialect //the sql flavour spoken by the driver
+	db               *sql.DB    //our connection pool, shared by every request
+	statements       *statementCache
+	schema           Schema //the tables we expose as resources
+	schemaLock       sync.RWMutex
+	tx               *sql.Tx //set when we are scoped to a transaction, statements then run within it
+}
+
+//opens the connection pool, sized by our configuration
+func (s *sqlStorage) open() *StorageError {
+	db, e := sql.Open(s.driver, s.ConnectionString)
+	if err := s.dialect.interpretError(e); err != nil {
+		return err
+	}
+	c := Config()
+	db.SetMaxOpenConns(c.MaxOpenConnections)
+	db.SetMaxIdleConns(c.MaxIdleConnections)
+	db.SetConnMaxLifetime(c.ConnectionMaxLifetime)
+	s.db = db
+	s.statements = newStatementCache(c.StatementCacheSize)
+	if err := s.ReloadSchema(); err != nil {
+		db.Close()
+		return err
+	}
+	return nil
+}
+
+//reads the tables and columns we expose from the database, call it again once the schema changes
+func (s *sqlStorage) ReloadSchema() *StorageError {
+	schema, e := s.dialect.loadSchema(s.db)
+	if err := s.dialect.interpretError(e); err != nil {
+		return err
+	}
+	if err := schema.applyKeys(Config().PrimaryKeys); err != nil {
+		return err
+	}
+	s.schemaLock.Lock()
+	s.schema = schema
+	s.schemaLock.Unlock()
+	return nil
+}
+
+//returns the table backing the resource, or a 404 if the schema has no such table
+func (s *sqlStorage) table(resource Resource) (*Table, *StorageError) {
+	s.schemaLock.RLock()
+	defer s.schemaLock.RUnlock()
+	return s.schema.table(resource)
+}
+
+//describes the resource as found in our schema
+func (s *sqlStorage) Describe(resource Resource) (*Table, *StorageError) {
+	return s.table(resource)
+}
+
+//quotes every identifier
+func (s *sqlStorage) quoteAll(identifiers []string) []string {
+	var quoted []string
+	for _, i := range identifiers {
+		quoted = append(quoted, s.dialect.quote(i))
+	}
+	return quoted
+}
+
+//closes the connection pool, intended for graceful shutdown
+//a transaction scoped storage leaves the pool open and rolls back instead, unless it was committed
+func (s *sqlStorage) Close() error {
+	if s.tx != nil {
+		if e := s.tx.Rollback(); e != nil && e != sql.ErrTxDone {
+			return e
+		}
+		return nil
+	}
+	s.statements.close()
+	return s.db.Close()
+}
+
+//interprets an error of a statement run with ctx, an ended ctx explains the error better than the driver can
+func (s *sqlStorage) interpret(ctx context.Context, e error) *StorageError {
+	if e == nil {
+		return nil
+	}
+	if err := contextError(ctx); err != nil {
+		return err
+	}
+	return s.dialect.interpretError(e)
+}
+
+//a prepared statement, bound to our transaction when we are scoped to one
+type statement struct {
+	*sql.Stmt
+	cached *cachedStatement //the statement it borrows from the cache, if any
+}
+
+//returns the statement to the cache, it must be called once done with
+func (s *statement) release() {
+	if s.cached == nil || s.Stmt != s.cached.Stmt {
+		s.Stmt.Close()
+	}
+	if s.cached != nil {
+		s.cached.release()
+	}
+}
+
+//returns a prepared statement for the query, it must be released once done with
+func (s *sqlStorage) prepare(ctx context.Context, query string) (*statement, *StorageError) {
+	if s.tx != nil {
+		//a transaction holds its connection, so it borrows a cached statement but prepares a missing one itself
+		//preparing on the pool could wait forever for a connection the transaction holds
+		if cached := s.statements.lookup(query); cached != nil {
+			return &statement{Stmt: s.tx.StmtContext(ctx, cached.Stmt), cached: cached}, nil
+		}
+		stmt, e := s.tx.PrepareContext(ctx, query)
+		if err := s.interpret(ctx, e); err != nil {
+			return nil, err
+		}
+		return &statement{Stmt: stmt}, nil
+	}
+
+	stmt, e := s.statements.get(query, func(query string) (*sql.Stmt, error) {
+		return s.db.PrepareContext(ctx, query)
+	})
+	if err := s.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+	return &statement{Stmt: stmt.Stmt, cached: stmt}, nil
+}
+
+//scopes the given storage to a new transaction, sharing our pool, statements and schema
+//the transaction is rolled back should ctx be done before it is committed
+func (s *sqlStorage) begin(ctx context.Context, scoped *sqlStorage) *StorageError {
+	if s.tx != nil {
+		return &StorageError{Code: 500, Message: "transactions can't be nested"}
+	}
+	tx, e := s.db.BeginTx(ctx, nil)
+	if err := s.interpret(ctx, e); err != nil {
+		return err
+	}
+	s.schemaLock.RLock()
+	defer s.schemaLock.RUnlock()
+	scoped.ConnectionString = s.ConnectionString
+	scoped.driver = s.driver
+	scoped.dialect = s.dialect
+	scoped.db = s.db
+	scoped.statements = s.statements
+	scoped.schema = s.schema
+	scoped.tx = tx
+	return nil
+}
+
+//commits our transaction, the storage can't be used afterwards
+func (s *sqlStorage) Commit() *StorageError {
+	if s.tx == nil {
+		return &StorageError{Code: 500, Message: "not in a transaction"}
+	}
+	return s.dialect.interpretError(s.tx.Commit())
+}
+
+//rolls back our transaction, the storage can't be used afterwards
+func (s *sqlStorage) Rollback() *StorageError {
+	if s.tx == nil {
+		return &StorageError{Code: 500, Message: "not in a transaction"}
+	}
+	return s.dialect.interpretError(s.tx.Rollback())
+}
+
+//runs the work in a transaction, committing when it succeeds and rolling back when it fails
+//when we are already scoped to a transaction the work joins it, and our owner decides its fate
+func (s *sqlStorage) transaction(ctx context.Context, work func(tx *sql.Tx) *StorageError) *StorageError {
+	if s.tx != nil {
+		return work(s.tx)
+	}
+	tx, e := s.db.BeginTx(ctx, nil)
+	if err := s.interpret(ctx, e); err != nil {
+		return err
+	}
+	if err := work(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return s.interpret(ctx, tx.Commit())
+}
+
+//hit and miss counters for our prepared statements
+func (s *sqlStorage) StatementCacheStats() StatementCacheStats {
+	return s.statements.stats()
+}
+
+//returns n bind parameters starting after the given offset
+func (s *sqlStorage) placeholders(offset int, n int) []string {
+	var sss []string
+	for i := 1; i <= n; i++ {
+		sss = append(sss, s.dialect.placeholder(offset+i))
+	}
+	return sss
+}
+
+//builds the insert statement for the record and its bind values
+func (s *sqlStorage) insertSql(table *Table, record Record) (string, []interface{}, *StorageError) {
+	if err := table.validate(record); err != nil {
+		return "", nil, err
+	}
+
+	var keys []string
+	var values []interface{}
+
+	for _, k := range sortedKeys(record) {
+		keys = append(keys, s.dialect.quote(k))
+		values = append(values, record[k])
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", s.dialect.quote(table.Name), strings.Join(keys, ","), strings.Join(s.placeholders(0, len(keys)), ","))
+	return sql, values, nil
+}
+
+//builds the WHERE clause matching the key columns, bind parameters are numbered after the given offset
+func (s *sqlStorage) keyWhere(table *Table, key Record, offset int) (string, []interface{}) {
+	var conditions []string
+	var values []interface{}
+	for _, k := range table.Key {
+		values = append(values, key[k])
+		conditions = append(conditions, s.dialect.quote(k)+"="+s.dialect.placeholder(offset+len(values)))
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), values
+}
+
+//narrows the WHERE clause to rows also meeting the filters, their bind parameters are numbered after the given offset
+func (s *sqlStorage) narrow(table *Table, clause string, filters []Filter, offset int) (string, []interface{}, *StorageError) {
+	if len(filters) == 0 {
+		return clause, nil, nil
+	}
+	where, values, err := s.where(table, &Query{Filters: filters}, offset)
+	if err != nil {
+		return "", nil, err
+	}
+	return clause + " AND " + strings.TrimPrefix(where, " WHERE "), values, nil
+}
+
+func (s *sqlStorage) Create(ctx context.Context, resource Resource, record Record) (*Response, *StorageError) {
+	table, err := s.table(resource)
+	if err != nil {
+		return nil, err
+	}
+	sql, values, err := s.insertSql(table, record)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := s.prepare(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.release()
+
+	r, e := stmt.ExecContext(ctx, values...)
+
+	if err = s.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+
+	key, err := table.keyOf(record)
+	if err != nil {
+		if len(table.Key) != 1 {
+			//only a single generated column can be learnt from the driver, so the record can't be read back
+			return &Response{Created: 1}, nil
+		}
+		id, e := r.LastInsertId()
+		if err = s.interpret(ctx, e); err != nil {
+			return nil, err
+		}
+		key = Record{table.Key[0]: id}
+	}
+	return s.created(ctx, resource, key)
+}
+
+//reads back the record created with the key, so defaults and generated values reach the client
+func (s *sqlStorage) created(ctx context.Context, resource Resource, key Record) (*Response, *StorageError) {
+	result, err := s.Read(ctx, resource, MatchQuery(key, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	return &Response{Created: 1, Data: result.Data}, nil
+}
+
+//inserts the record or replaces the columns it holds on the row with its key, a row not meeting the where filters is a 404
+//the row is looked for and locked first, so its key alone decides between the two rather than any unique constraint,
+//which also lets us upsert tables whose key is only configured and so isn't enforced by the database
+func (s *sqlStorage) Upsert(ctx context.Context, resource Resource, record Record, where ...Filter) (*Response, *StorageError) {
+	table, err := s.table(resource)
+	if err != nil {
+		return nil, err
+	}
+	if err = table.validate(record); err != nil {
+		return nil, err
+	}
+	key, err := table.keyOf(record)
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []Record
+	err = s.transaction(ctx, func(tx *sql.Tx) *StorageError {
+		clause, values := s.keyWhere(table, key, 0)
+		var err *StorageError
+		if existing, err = s.selectKeys(ctx, tx, table, clause+s.dialect.lockRows(), values); err != nil {
+			return err
+		}
+		if len(existing) > 0 && len(where) > 0 {
+			//the row is locked, so it still meets the filters when it is written
+			narrowed, filterValues, err := s.narrow(table, clause, where, len(values))
+			if err != nil {
+				return err
+			}
+			meeting, err := s.selectKeys(ctx, tx, table, narrowed, append(values, filterValues...))
+			if err != nil {
+				return err
+			}
+			if len(meeting) == 0 {
+				return &StorageError{Code: 404, Message: "record not found"}
+			}
+		}
+
+		statement, values := s.updateSql(table, record, key)
+		if len(existing) == 0 {
+			if statement, values, err = s.insertSql(table, record); err != nil {
+				return err
+			}
+		}
+		if statement == "" {
+			//the record holds nothing but its key, which the row already has
+			return nil
+		}
+		if _, e := tx.ExecContext(ctx, statement, values...); e != nil {
+			return s.interpret(ctx, e)
+		}
+		if len(existing) == 0 {
+			return s.interpret(ctx, s.dialect.advanceKey(ctx, tx, table))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(existing) == 0 {
+		return s.created(ctx, resource, key)
+	}
+	return &Response{Updated: 1}, nil
+}
+
+//the sql operator for each filter operator with a single value
+var sqlOperators = map[string]string{Eq: "=", Ne: "<>", Gt: ">", Gte: ">=", Lt: "<", Lte: "<=", Like: "LIKE"}
+
+//builds the WHERE clause for the filters and cursor of the query, bind parameters are numbered after the given offset
+func (s *sqlStorage) where(table *Table, query *Query, offset int) (string, []interface{}, *StorageError) {
+	var conditions []string
+	var values []interface{}
+	for _, f := range query.Filters {
+		if !table.hasColumn(f.Column) {
+			return "", nil, &StorageError{Code: 400, Message: fmt.Sprintf("unknown field '%s'", f.Column)}
+		}
+		column := s.dialect.quote(f.Column)
+
+		switch f.Operator {
+		case In:
+			list, ok := f.Value.([]interface{})
+			if !ok {
+				return "", nil, &StorageError{Code: 400, Message: fmt.Sprintf("'%s' expects a list", f.Operator)}
+			}
+			if len(list) == 0 {
+				conditions = append(conditions, "1 = 0")
+				continue
+			}
+			conditions = append(conditions, fmt.Sprintf("%s IN (%s)", column, strings.Join(s.placeholders(offset+len(values), len(list)), ",")))
+			values = append(values, list...)
+		case Null:
+			if isNull, _ := f.Value.(bool); isNull {
+				conditions = append(conditions, column+" IS NULL")
+			} else {
+				conditions = append(conditions, column+" IS NOT NULL")
+			}
+		default:
+			operator, ok := sqlOperators[f.Operator]
+			if !ok {
+				return "", nil, &StorageError{Code: 400, Message: fmt.Sprintf("unknown operator '%s'", f.Operator)}
+			}
+			values = append(values, f.Value)
+			conditions = append(conditions, fmt.Sprintf("%s %s %s", column, operator, s.dialect.placeholder(offset+len(values))))
+		}
+	}
+
+	if query.After != nil {
+		if len(query.After) != len(query.Sort) {
+			return "", nil, &StorageError{Code: 400, Message: "the cursor does not match the sort"}
+		}
+		//(a, b) > (x, y) written out as a > x OR (a = x AND b > y) so each key can have its own direction
+		var alternatives []string
+		for i, k := range query.Sort {
+			var terms []string
+			for j, previous := range query.Sort[:i] {
+				term, value := s.cursorTerm(previous.Column, "=", query.After[j], offset+len(values)+1)
+				terms = append(terms, term)
+				values = append(values, value...)
+			}
+			var term string
+			var value []interface{}
+			switch {
+			case !k.Descending && query.After[i] == nil:
+				term = s.dialect.quote(k.Column) + " IS NOT NULL"
+			case !k.Descending:
+				term, value = s.cursorTerm(k.Column, ">", query.After[i], offset+len(values)+1)
+			case query.After[i] == nil:
+				//nulls come last in a descending sort, nothing follows them
+				term = "1 = 0"
+			default:
+				term, value = s.cursorTerm(k.Column, "<", query.After[i], offset+len(values)+1)
+				term = fmt.Sprintf("(%s OR %s IS NULL)", term, s.dialect.quote(k.Column))
+			}
+			terms = append(terms, term)
+			values = append(values, value...)
+			alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	if len(conditions) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), values, nil
+}
+
+//compares a column with a cursor value bound as the nth parameter, a null value can only be equalled
+func (s *sqlStorage) cursorTerm(column string, operator string, value interface{}, n int) (string, []interface{}) {
+	if value == nil {
+		return s.dialect.quote(column) + " IS NULL", nil
+	}
+	return fmt.Sprintf("%s %s %s", s.dialect.quote(column), operator, s.dialect.placeholder(n)), []interface{}{value}
+}
+
+//builds the ORDER BY clause for the sort keys
+//nulls sort before every value, as the databases disagree on where they go
+func (s *sqlStorage) orderBy(table *Table, sort []Sort) (string, *StorageError) {
+	if len(sort) == 0 {
+		return "", nil
+	}
+
+	var keys []string
+	for _, k := range sort {
+		if !table.hasColumn(k.Column) {
+			return "", &StorageError{Code: 400, Message: fmt.Sprintf("unknown field '%s'", k.Column)}
+		}
+		column := s.dialect.quote(k.Column)
+		if k.Descending {
+			keys = append(keys, "("+column+" IS NULL) ASC", column+" DESC")
+		} else {
+			keys = append(keys, "("+column+" IS NULL) DESC", column+" ASC")
+		}
+	}
+	return " ORDER BY " + strings.Join(keys, ", "), nil
+}
+
+func (s *sqlStorage) Read(ctx context.Context, resource Resource, query *Query) (*Response, *StorageError) {
+
+	table, err := s.table(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	orderBy, err := s.orderBy(table, query.Sort)
+	if err != nil {
+		return nil, err
+	}
+
+	where, paramValues, err := s.where(table, query, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := "*"
+	if len(query.Fields) > 0 {
+		for _, f := range query.Fields {
+			if !table.hasColumn(f) {
+				return nil, &StorageError{Code: 400, Message: fmt.Sprintf("unknown field '%s'", f)}
+			}
+		}
+		columns = strings.Join(s.quoteAll(query.Fields), ",")
+	}
+	sqlString := fmt.Sprintf("SELECT %s FROM %s", columns, s.dialect.quote(table.Name)) + where + orderBy
+
+	paramValues = append(paramValues, query.Limit)
+	paramValues = append(paramValues, query.Offset)
+
+	sqlString += fmt.Sprintf(" LIMIT %s OFFSET %s", s.dialect.placeholder(len(paramValues)-1), s.dialect.placeholder(len(paramValues)))
+
+	stmt, err := s.prepare(ctx, sqlString)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.release()
+
+	rows, e := stmt.QueryContext(ctx, paramValues...)
+
+	if err = s.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tableData, e := scanRecords(rows)
+	if err = s.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+	result := Response{Data: tableData}
+
+	if query.Count {
+		total, err := s.count(ctx, table, query)
+		if err != nil {
+			return nil, err
+		}
+		result.Meta = &Meta{Total: total}
+	}
+	return &result, nil
+}
+
+//counts every record meeting the filters of the query, regardless of its cursor
+func (s *sqlStorage) count(ctx context.Context, table *Table, query *Query) (int64, *StorageError) {
+	where, values, err := s.where(table, &Query{Filters: query.Filters}, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err := s.prepare(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s", s.dialect.quote(table.Name)) + where)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.release()
+
+	var total int64
+	e := stmt.QueryRowContext(ctx, values...).Scan(&total)
+	if err = s.interpret(ctx, e); err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+//builds the statement setting the record's columns on the row with the key, empty when the record holds nothing but its key
+func (s *sqlStorage) updateSql(table *Table, record Record, key Record) (string, []interface{}) {
+	var sets []string
+	var values []interface{}
+	for _, k := range sortedKeys(record) {
+		if table.isKey(k) {
+			continue
+		}
+		values = append(values, record[k])
+		sets = append(sets, s.dialect.quote(k)+"="+s.dialect.placeholder(len(values)))
+	}
+	if len(sets) == 0 {
+		return "", nil
+	}
+
+	where, keyValues := s.keyWhere(table, key, len(values))
+	return fmt.Sprintf("UPDATE %s SET %s", s.dialect.quote(table.Name), strings.Join(sets, ",")) + where, append(values, keyValues...)
+}
+
+//updates the row with the record's key, should it meet the where filters
+func (s *sqlStorage) Update(ctx context.Context, resource Resource, record Record, where ...Filter) (*Response, *StorageError) {
+
+	table, err := s.table(resource)
+	if err != nil {
+		return nil, err
+	}
+	if err = table.validate(record); err != nil {
+		return nil, err
+	}
+	key, err := table.keyOf(record)
+	if err != nil {
+		return nil, err
+	}
+
+	sql, values := s.updateSql(table, record, key)
+	if sql == "" {
+		return nil, &StorageError{Code: 400, Message: "no fields to update"}
+	}
+	sql, filterValues, err := s.narrow(table, sql, where, len(values))
+	if err != nil {
+		return nil, err
+	}
+	values = append(values, filterValues...)
+
+	stmt, err := s.prepare(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.release()
+
+	r, e := stmt.ExecContext(ctx, values...)
+	if err = s.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+
+	rows, e := r.RowsAffected()
+	if err = s.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+
+	return &Response{Updated: rows}, nil
+}
+
+//deletes the row with the record's key, should it meet the where filters
+func (s *sqlStorage) Delete(ctx context.Context, resource Resource, record Record, where ...Filter) (*Response, *StorageError) {
+
+	table, err := s.table(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := table.keyOf(record)
+	if err != nil {
+		return nil, err
+	}
+
+	clause, values := s.keyWhere(table, key, 0)
+	clause, filterValues, err := s.narrow(table, clause, where, len(values))
+	if err != nil {
+		return nil, err
+	}
+	values = append(values, filterValues...)
+	sql := fmt.Sprintf("DELETE FROM %s", s.dialect.quote(table.Name)) + clause
+	stmt, err := s.prepare(ctx, sql)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.release()
+
+	r, e := stmt.ExecContext(ctx, values...)
+	if err = s.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+	rows, e := r.RowsAffected()
+	if err = s.interpret(ctx, e); err != nil {
+		return nil, err
+	}
+	return &Response{Deleted: rows}, nil
+}
+
+//scans every row of a result set into records keyed by column name
+func scanRecords(rows *sql.Rows) (Records, error) {
+	columns, e := rows.Columns()
+	if e != nil {
+		return nil, e
+	}
+
+	tableData := Records{}
+
+	count := len(columns)
+	values := make([]interface{}, count)
+	scanArgs := make([]interface{}, count)
+	for i := range values {
+		scanArgs[i] = &values[i]
+	}
+
+	for rows.Next() {
+		if e := rows.Scan(scanArgs...); e != nil {
+			return nil, e
+		}
+
+		entry := Record{}
+		for i, col := range columns {
+			v := values[i]
+
+			b, ok := v.([]byte)
+			if ok {
+				entry[col] = string(b)
+			} else {
+				entry[col] = v
+			}
+		}
+
+		tableData = append(tableData, entry)
+	}
+	return tableData, rows.Err()
+}
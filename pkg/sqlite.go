@@ -0,0 +1,119 @@
+package pkg
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+type SqliteStorage struct {
+	sqlStorage
+	keepAlive *sql.DB //an in memory database only lives as long as a connection to it, this one is held until Close
+}
+
+//numbers the in memory databases opened, so each storage gets one of its own
+var memoryDatabases int64
+
+//opens the sqlite database at the connection string, a file path or :memory:, it should be closed when no longer needed
+func NewSqliteStorage(connectionString string) (*SqliteStorage, *StorageError) {
+	s := SqliteStorage{sqlStorage: sqlStorage{ConnectionString: connectionString, driver: "sqlite3", dialect: sqliteDialect{}}}
+	if connectionString == ":memory:" {
+		//every connection to :memory: gets a fresh database, a named one with a shared cache is seen by all of ours
+		s.ConnectionString = fmt.Sprintf("file:veil_memory_%d?mode=memory&cache=shared", atomic.AddInt64(&memoryDatabases, 1))
+		db, e := sql.Open(s.driver, s.ConnectionString)
+		if e == nil {
+			e = db.Ping()
+		}
+		if err := interpretSqliteError(e); err != nil {
+			return nil, err
+		}
+		s.keepAlive = db
+	}
+	if err := s.open(); err != nil {
+		if s.keepAlive != nil {
+			s.keepAlive.Close()
+		}
+		return nil, err
+	}
+	if s.keepAlive != nil {
+		//connections sharing a cache fail on each other's locks rather than wait, so statements take turns on one
+		s.db.SetMaxOpenConns(1)
+	}
+	return &s, nil
+}
+
+func (s *SqliteStorage) Close() error {
+	if s.keepAlive != nil {
+		s.keepAlive.Close()
+	}
+	return s.sqlStorage.Close()
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) placeholder(n int) string {
+	return "?"
+}
+
+func (sqliteDialect) interpretError(err error) *StorageError {
+	return interpretSqliteError(err)
+}
+
+func (sqliteDialect) quote(identifier string) string {
+	return `"` + strings.Replace(identifier, `"`, `""`, -1) + `"`
+}
+
+func (sqliteDialect) loadSchema(db *sql.DB) (Schema, error) {
+	return querySchema(db, `SELECT m.name, c.name, c.pk FROM sqlite_master m JOIN pragma_table_info(m.name) c
+		WHERE m.type IN ('table', 'view') AND m.name NOT LIKE 'sqlite_%' ORDER BY m.name, c.cid`)
+}
+
+//sqlite has no row locks, it allows a single writer at a time instead
+func (sqliteDialect) lockRows() string {
+	return ""
+}
+
+func (sqliteDialect) returning(columns string) string {
+	return " RETURNING " + columns
+}
+
+//a rowid is always chosen past the largest one, explicitly inserted or not
+func (sqliteDialect) advanceKey(ctx context.Context, tx *sql.Tx, table *Table) error {
+	return nil
+}
+
+//interprets a sqlite error and returns it as a Storage Error
+func interpretSqliteError(err error) *StorageError {
+	if err != nil {
+		switch err.(type) {
+		case sqlite3.Error:
+			e := err.(sqlite3.Error)
+			switch {
+			case e.Code == sqlite3.ErrError && strings.HasPrefix(e.Error(), "no such table"):
+				return &StorageError{Code: 404, Message: "resource not found", WrapsError: err}
+			case e.ExtendedCode == sqlite3.ErrConstraintNotNull:
+				return &StorageError{Code: 400, Message: "resource does not include all required values", WrapsError: err}
+			case e.ExtendedCode == sqlite3.ErrConstraintUnique, e.ExtendedCode == sqlite3.ErrConstraintPrimaryKey:
+				return &StorageError{Code: 409, Message: "resource already exists", WrapsError: err}
+			default:
+				return &StorageError{Code: 400, Message: "unknown error", WrapsError: err}
+			}
+
+		default:
+			return &StorageError{Code: 400, Message: "unknown error", WrapsError: err}
+		}
+	}
+	return nil
+}
+
+func (s *SqliteStorage) Begin(ctx context.Context) (Tx, *StorageError) {
+	scoped := SqliteStorage{}
+	if err := s.begin(ctx, &scoped.sqlStorage); err != nil {
+		return nil, err
+	}
+	return &scoped, nil
+}
@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSqliteMemoryStorage(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewSqliteStorage(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, e := s.db.Exec(`CREATE TABLE veil_test_memory (id INTEGER PRIMARY KEY AUTOINCREMENT, body varchar(255))`); e != nil {
+		t.Fatal(e)
+	}
+	if err = s.ReloadSchema(); err != nil {
+		t.Fatal(err)
+	}
+	memory := Resource{"veil_test_memory"}
+
+	other, err := NewSqliteStorage(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err = other.Describe(memory); err == nil || err.Code != 404 {
+		t.Errorf("each in memory storage expected a database of its own, got %v", err)
+	}
+
+	//a read waits for an open transaction rather than failing on its lock
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+	if _, err = tx.Create(ctx, memory, Record{"body": "pending"}); err != nil {
+		t.Fatal(err)
+	}
+	read := make(chan *Response, 1)
+	go func() {
+		result, err := s.Read(ctx, memory, &Query{Limit: 10})
+		if err != nil {
+			t.Errorf("a read during a transaction expected to wait for it, got %v", err)
+		}
+		read <- result
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if result := <-read; result != nil && len(result.Data) != 1 {
+		t.Errorf("a read after the transaction expected its record, got %v", result.Data)
+	}
+}
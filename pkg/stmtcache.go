@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"container/list"
+	"database/sql"
+	"sync"
+)
+
+//a bounded, least recently used cache of prepared statements keyed by their sql
+//statements are reference counted so one evicted while in use is only closed once released
+type statementCache struct {
+	lock     sync.Mutex
+	capacity int                      //the most statements kept prepared, 0 disables caching
+	entries  map[string]*list.Element //our statements by sql
+	order    *list.List               //our statements, most recently used at the front
+	hits     int64
+	misses   int64
+}
+
+//a prepared statement borrowed from the cache, it must be released once done with
+type cachedStatement struct {
+	*sql.Stmt
+	query   string
+	refs    int  //how many callers are using the statement
+	evicted bool //whether the statement has left the cache and should be closed once unused
+	cache   *statementCache
+}
+
+//hit and miss counters for the statement cache
+type StatementCacheStats struct {
+	Hits     int64 `json:"hits"`
+	Misses   int64 `json:"misses"`
+	Size     int   `json:"size"`
+	Capacity int   `json:"capacity"`
+}
+
+func newStatementCache(capacity int) *statementCache {
+	return &statementCache{capacity: capacity, entries: map[string]*list.Element{}, order: list.New()}
+}
+
+//returns the cached statement for the query, preparing it on a miss
+func (c *statementCache) get(query string, prepare func(query string) (*sql.Stmt, error)) (*cachedStatement, error) {
+	c.lock.Lock()
+	if e, ok := c.entries[query]; ok {
+		c.hits++
+		c.order.MoveToFront(e)
+		s := e.Value.(*cachedStatement)
+		s.refs++
+		c.lock.Unlock()
+		return s, nil
+	}
+	c.misses++
+	c.lock.Unlock()
+
+	//prepare outside the lock so a slow round trip doesn't hold up other queries
+	stmt, err := prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	s := &cachedStatement{Stmt: stmt, query: query, refs: 1, cache: c}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.entries[query]; ok {
+		//another caller prepared the same query meanwhile, keep theirs
+		stmt.Close()
+		s = e.Value.(*cachedStatement)
+		s.refs++
+		return s, nil
+	}
+	if c.capacity < 1 {
+		s.evicted = true
+		return s, nil
+	}
+	c.entries[query] = c.order.PushFront(s)
+	for c.order.Len() > c.capacity {
+		c.evict(c.order.Back())
+	}
+	return s, nil
+}
+
+//returns the cached statement for the query without preparing it on a miss, or nil
+func (c *statementCache) lookup(query string) *cachedStatement {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e, ok := c.entries[query]
+	if !ok {
+		return nil
+	}
+	c.hits++
+	c.order.MoveToFront(e)
+	s := e.Value.(*cachedStatement)
+	s.refs++
+	return s
+}
+
+//removes the element from the cache, the lock must be held
+func (c *statementCache) evict(e *list.Element) {
+	s := c.order.Remove(e).(*cachedStatement)
+	delete(c.entries, s.query)
+	s.evicted = true
+	if s.refs == 0 {
+		s.Stmt.Close()
+	}
+}
+
+//returns the statement to the cache
+func (s *cachedStatement) release() {
+	s.cache.lock.Lock()
+	defer s.cache.lock.Unlock()
+	s.refs--
+	if s.evicted && s.refs == 0 {
+		s.Stmt.Close()
+	}
+}
+
+//evicts every statement, in use statements are closed as they are released
+func (c *statementCache) close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for c.order.Len() > 0 {
+		c.evict(c.order.Back())
+	}
+}
+
+func (c *statementCache) stats() StatementCacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return StatementCacheStats{Hits: c.hits, Misses: c.misses, Size: c.order.Len(), Capacity: c.capacity}
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestStatementCache(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	check(err)
+	defer db.Close()
+
+	c := newStatementCache(2)
+	get := func(query string) *cachedStatement {
+		s, err := c.get(query, db.Prepare)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	one := get("SELECT 1")
+	one.release()
+	if get("SELECT 1") != one {
+		t.Errorf("expected the cached statement to be reused")
+	}
+	one.release()
+
+	two := get("SELECT 2")
+	two.release()
+	get("SELECT 1").release()
+	held := get("SELECT 2")
+	get("SELECT 3").release() //evicts SELECT 1, the least recently used
+
+	if stats := c.stats(); stats.Hits != 3 || stats.Misses != 3 || stats.Size != 2 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	again := get("SELECT 1") //evicts SELECT 2 while it is held
+	if again == one {
+		t.Errorf("expected the evicted statement to be prepared again")
+	}
+	again.release()
+
+	//an evicted statement stays usable until released
+	var v int
+	if err := held.QueryRow().Scan(&v); err != nil || v != 2 {
+		t.Errorf("expected an evicted statement in use to remain open, got %v", err)
+	}
+	held.release()
+	if err := held.QueryRow().Scan(&v); err == nil {
+		t.Errorf("expected an evicted statement to be closed once released")
+	}
+}
+
+func TestStatementCacheDisabled(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	check(err)
+	defer db.Close()
+
+	c := newStatementCache(0)
+	s, err := c.get("SELECT 1", db.Prepare)
+	check(err)
+	s.release()
+	if stats := c.stats(); stats.Size != 0 || stats.Misses != 1 {
+		t.Errorf("expected nothing cached, got %+v", stats)
+	}
+	if err := s.QueryRow().Scan(new(int)); err == nil {
+		t.Errorf("expected an uncached statement to be closed once released")
+	}
+}
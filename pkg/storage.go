@@ -1,7 +1,9 @@
 package pkg
 
 import (
+	"context"
 	"fmt"
+	"sort"
 )
 
 //provides a typed error interface for the storage interface methods to return
@@ -16,12 +18,40 @@ func (e *StorageError) Error() string {
 	return fmt.Sprintf("%d: %s", e.Code, e.Message)
 }
 
+//returns the error for an operation whose context ended, or nil while the context is live
+//a deadline is our query timeout and maps to a 504, a cancellation means the client has gone
+func contextError(ctx context.Context) *StorageError {
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return &StorageError{Code: 504, Message: "the query timed out", WrapsError: ctx.Err()}
+	case context.Canceled:
+		return &StorageError{Code: 499, Message: "the request was cancelled", WrapsError: ctx.Err()}
+	}
+	return nil
+}
+
 //provides an abstraction for the database layer
+//operations stop once their context ends, returning the StorageError from contextError
 type Storage interface {
-	Create(resource Resource, record Record) (*Response, *StorageError) //Creates an entry in the data store, intended for use with PUT
-	Read(resource Resource, match *Record, offset int, limit int) (*Response, *StorageError) //Reads from the data store, intended for use with GET
-	Update(resource Resource, record Record) (*Response, *StorageError) //Updates a record in the data store
-	Delete(resource Resource, record Record) (*Response, *StorageError) //Deletes a record in the data store
+	Create(ctx context.Context, resource Resource, record Record) (*Response, *StorageError)                        //Creates an entry in the data store, intended for use with PUT
+	Read(ctx context.Context, resource Resource, query *Query) (*Response, *StorageError)                           //Reads from the data store, intended for use with GET
+	Update(ctx context.Context, resource Resource, record Record, where ...Filter) (*Response, *StorageError)       //Updates the record with its key, should it meet the where filters
+	Upsert(ctx context.Context, resource Resource, record Record, where ...Filter) (*Response, *StorageError)       //Creates the record or replaces the one with its key, a 404 should that one not meet the where filters, intended for use with PUT /resource/key
+	Delete(ctx context.Context, resource Resource, record Record, where ...Filter) (*Response, *StorageError)       //Deletes the record with its key, should it meet the where filters
+	BulkCreate(ctx context.Context, resource Resource, records Records) (*Response, *StorageError)                  //Creates every record in one transaction, intended for use with PUT of an array
+	BulkUpdate(ctx context.Context, resource Resource, records Records, where ...Filter) (*Response, *StorageError) //Updates every record by its key in one transaction, records not meeting the where filters are 404 items, intended for use with POST of an array
+	BulkDelete(ctx context.Context, resource Resource, query *Query) (*Response, *StorageError)                     //Deletes every record meeting the query's filters in one transaction
+	Describe(resource Resource) (*Table, *StorageError)                                                             //Describes the resource's columns and primary key, so handlers can address its records
+	Begin(ctx context.Context) (Tx, *StorageError)                                                                  //Starts a transaction ending with ctx, it must be committed, rolled back or closed
+	Close() error                                                                                                   //Releases any connections held, intended for graceful shutdown
+}
+
+//a Storage scoped to a transaction, its writes are only kept once committed
+//closing it without committing rolls it back
+type Tx interface {
+	Storage
+	Commit() *StorageError   //Keeps every write made through the transaction
+	Rollback() *StorageError //Discards every write made through the transaction
 }
 
 //a resource represents the table or document within the database
@@ -35,11 +65,71 @@ type Record map[string]interface{} //the data held in the resource
 // a collection of records
 type Records []Record
 
-//our storage factory
+//the keys of the record in a stable order, so identical requests generate identical sql
+func sortedKeys(record Record) []string {
+	var keys []string
+	for k := range record {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//the comparisons a filter can make
+const (
+	Eq   = "eq"   //column = value
+	Ne   = "ne"   //column <> value
+	Gt   = "gt"   //column > value
+	Gte  = "gte"  //column >= value
+	Lt   = "lt"   //column < value
+	Lte  = "lte"  //column <= value
+	Like = "like" //column LIKE value, % and _ are wildcards
+	In   = "in"   //column IN value, value is a []interface{}
+	Null = "null" //column IS NULL when value is true, IS NOT NULL when false
+)
+
+//a condition records must meet to be read
+type Filter struct {
+	Column   string      //the column compared
+	Operator string      //one of the operator constants
+	Value    interface{} //what the column is compared with
+}
+
+//orders records by a column
+type Sort struct {
+	Column     string //the column ordered by
+	Descending bool   //whether the largest values come first, nulls are smaller than every value
+}
+
+//describes which records of a resource to read
+type Query struct {
+	Fields  []string      //the columns to return, all of them when empty
+	Filters []Filter      //conditions every record must meet
+	Sort    []Sort        //the order of the records, by the first key then the next
+	After   []interface{} //a cursor, the sort key values of the last record read, only records after it in sort order are read
+	Offset  int           //how many matching records to skip
+	Limit   int           //the most records to return
+	Count   bool          //whether to also count every record meeting the filters into the response Meta
+}
+
+//a query for the records whose columns equal the record's values
+func MatchQuery(match Record, offset int, limit int) *Query {
+	q := Query{Offset: offset, Limit: limit}
+	for _, k := range sortedKeys(match) {
+		q.Filters = append(q.Filters, Filter{Column: k, Operator: Eq, Value: match[k]})
+	}
+	return &q
+}
+
+//our storage factory, the storage holds a connection pool so it is intended to be created once at startup and shared
 func NewStorage() (Storage, *StorageError) {
-	if c := Config(); c.DB == "MYSQL" {
-		s := MySqlStorage{c.ConnectionString}
-		return &s, nil
+	switch c := Config(); c.DB {
+	case "MYSQL":
+		return NewMySqlStorage(c.ConnectionString)
+	case "POSTGRES":
+		return NewPostgresStorage(c.ConnectionString)
+	case "SQLITE":
+		return NewSqliteStorage(c.ConnectionString)
 	}
-	return nil, &StorageError{}
+	return nil, &StorageError{Code: 500, Message: "unsupported database " + Config().DB}
 }
@@ -0,0 +1,89 @@
+package pkg_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/vlaurenzano/veil/pkg"
+	"github.com/vlaurenzano/veil/pkg/storagetest"
+)
+
+//the conformance table definition for each database/sql driver
+var conformanceTables = map[string]string{
+	"mysql": `CREATE TABLE veil_conformance (
+		id int(11) NOT NULL AUTO_INCREMENT,
+		test_field_1 varchar(255) NOT NULL,
+		test_field_2 varchar(255) NOT NULL,
+		test_unique varchar(255) DEFAULT NULL,
+		PRIMARY KEY (id),
+		UNIQUE KEY (test_unique)
+	) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;`,
+	"postgres": `CREATE TABLE veil_conformance (
+		id SERIAL PRIMARY KEY,
+		test_field_1 varchar(255) NOT NULL,
+		test_field_2 varchar(255) NOT NULL,
+		test_unique varchar(255) UNIQUE
+	);`,
+	"sqlite3": `CREATE TABLE veil_conformance (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		test_field_1 varchar(255) NOT NULL,
+		test_field_2 varchar(255) NOT NULL,
+		test_unique varchar(255) UNIQUE
+	);`,
+}
+
+//recreates an empty conformance table
+func resetConformanceTable(t *testing.T, driver string, connectionString string) {
+	db, err := sql.Open(driver, connectionString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, s := range []string{"DROP TABLE IF EXISTS veil_conformance;", conformanceTables[driver]} {
+		if _, err = db.Exec(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMemoryStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) pkg.Storage {
+		m := pkg.NewMemoryStorage()
+		m.AddResource(storagetest.Resource, []string{"test_field_1", "test_field_2", "test_unique"}, []string{"test_field_1", "test_field_2"})
+		m.AddUnique(storagetest.Resource, "test_unique")
+		return m
+	})
+}
+
+func TestSqliteStorageConformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) pkg.Storage {
+		path := filepath.Join(t.TempDir(), "conformance.db")
+		resetConformanceTable(t, "sqlite3", path)
+		s, err := pkg.NewSqliteStorage(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+//runs against the configured server database, if there is one
+func TestServerStorageConformance(t *testing.T) {
+	drivers := map[string]string{"MYSQL": "mysql", "POSTGRES": "postgres"}
+	c := pkg.Config()
+	driver, ok := drivers[c.DB]
+	if !ok {
+		t.Skip("VEIL_DB is not a server database")
+	}
+
+	storagetest.Run(t, func(t *testing.T) pkg.Storage {
+		resetConformanceTable(t, driver, c.ConnectionString)
+		s, err := pkg.NewStorage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
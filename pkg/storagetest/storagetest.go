@@ -0,0 +1,770 @@
+//Package storagetest checks a Storage implementation honours the contract veil's handlers rely on.
+//
+//A backend proves itself with a single test:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, func(t *testing.T) pkg.Storage {
+//			return newStorageWithEmptyConformanceTable(t)
+//		})
+//	}
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/vlaurenzano/veil/pkg"
+)
+
+//the resource every factory must provide, empty, with an auto incremented "id" primary key,
+//the two required string columns "test_field_1" and "test_field_2" and the nullable string column "test_unique"
+//no two records may share a value of
+var Resource = pkg.Resource{Identifier: "veil_conformance"}
+
+//a resource the factory must not provide
+var Missing = pkg.Resource{Identifier: "veil_conformance_missing"}
+
+//returns a storage holding an empty Resource, it is called once per sub test and closed when the sub test ends
+type Factory func(t *testing.T) pkg.Storage
+
+//runs every conformance check against storages returned by the factory
+func Run(t *testing.T, factory Factory) {
+	checks := []struct {
+		name  string
+		check func(t *testing.T, s pkg.Storage)
+	}{
+		{"Create", testCreate},
+		{"Read", testRead},
+		{"Filters", testFilters},
+		{"Sort", testSort},
+		{"Fields", testFields},
+		{"After", testAfter},
+		{"Count", testCount},
+		{"Update", testUpdate},
+		{"Upsert", testUpsert},
+		{"Delete", testDelete},
+		{"Where", testWhere},
+		{"BulkCreate", testBulkCreate},
+		{"BulkUpdate", testBulkUpdate},
+		{"BulkDelete", testBulkDelete},
+		{"Transaction", testTransaction},
+		{"Context", testContext},
+		{"MissingResource", testMissingResource},
+		{"UnknownColumn", testUnknownColumn},
+		{"Describe", testDescribe},
+	}
+	for _, c := range checks {
+		check := c.check
+		t.Run(c.name, func(t *testing.T) {
+			s := factory(t)
+			defer s.Close()
+			check(t, s)
+		})
+	}
+}
+
+//creates x records with test_field_1 set to value_0 ... value_x-1
+func seed(t *testing.T, s pkg.Storage, x int) {
+	for i := 0; i < x; i++ {
+		r, err := s.Create(context.Background(), Resource, pkg.Record{"test_field_1": fmt.Sprintf("value_%d", i), "test_field_2": "seed"})
+		if err != nil {
+			t.Fatalf("seeding record %d failed: %v", i, err)
+		}
+		if r.Created != 1 {
+			t.Fatalf("seeding record %d reported %d created", i, r.Created)
+		}
+	}
+}
+
+func read(t *testing.T, s pkg.Storage, match pkg.Record, offset int, limit int) pkg.Records {
+	return readQuery(t, s, pkg.MatchQuery(match, offset, limit))
+}
+
+func readQuery(t *testing.T, s pkg.Storage, query *pkg.Query) pkg.Records {
+	r, err := s.Read(context.Background(), Resource, query)
+	if err != nil {
+		t.Fatalf("read of %+v failed: %v", *query, err)
+	}
+	return r.Data
+}
+
+//creates a record without an id after one was written with the given explicit id
+//the id generated must not be the one already taken, as a sequence not moved past it would give
+func createAfterExplicit(t *testing.T, s pkg.Storage, explicit string) {
+	r, err := s.Create(context.Background(), Resource, pkg.Record{"test_field_1": "generated", "test_field_2": "after " + explicit})
+	if err != nil {
+		t.Fatalf("create without an id after the explicit id %s failed: %v", explicit, err)
+	}
+	if len(r.Data) != 1 || r.Data[0]["id"] == nil || fmt.Sprint(r.Data[0]["id"]) == explicit {
+		t.Errorf("create without an id after the explicit id %s expected a new id, got %v", explicit, r.Data)
+	}
+}
+
+//the id of the record whose test_field_1 holds the given value
+func idOf(t *testing.T, s pkg.Storage, value string) interface{} {
+	data := read(t, s, pkg.Record{"test_field_1": value}, 0, 10)
+	if len(data) != 1 {
+		t.Fatalf("expected one record with test_field_1 %s, got %d", value, len(data))
+	}
+	return data[0]["id"]
+}
+
+func testCreate(t *testing.T, s pkg.Storage) {
+	r, err := s.Create(context.Background(), Resource, pkg.Record{"test_field_1": "a", "test_field_2": "b"})
+	if err != nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if r.Created != 1 {
+		t.Errorf("create reported %d created, expected 1", r.Created)
+	}
+	if len(r.Data) != 1 || r.Data[0]["id"] == nil || r.Data[0]["test_field_1"] != "a" || r.Data[0]["test_field_2"] != "b" {
+		t.Errorf("create expected the created record with its id, got %v", r.Data)
+	}
+
+	data := read(t, s, nil, 0, 10)
+	if len(data) != 1 || data[0]["test_field_1"] != "a" || data[0]["test_field_2"] != "b" {
+		t.Errorf("created record not read back, got %v", data)
+	}
+	if data[0]["id"] == nil {
+		t.Errorf("created record was not assigned an id")
+	}
+	if len(r.Data) == 1 && fmt.Sprint(r.Data[0]["id"]) != fmt.Sprint(data[0]["id"]) {
+		t.Errorf("create returned id %v, the record was stored with %v", r.Data[0]["id"], data[0]["id"])
+	}
+
+	_, err = s.Create(context.Background(), Resource, pkg.Record{"test_field_1": "a"})
+	if err == nil || err.Code != 400 {
+		t.Errorf("create without a required value expected a 400, got %v", err)
+	}
+
+	if _, err = s.Create(context.Background(), Resource, pkg.Record{"id": "500", "test_field_1": "a", "test_field_2": "b"}); err != nil {
+		t.Fatalf("create with an explicit id failed: %v", err)
+	}
+	createAfterExplicit(t, s, "500")
+}
+
+func testRead(t *testing.T, s pkg.Storage) {
+	seed(t, s, 5)
+
+	if data := read(t, s, nil, 0, 10); len(data) != 5 {
+		t.Errorf("read without a match expected 5 records, got %d", len(data))
+	}
+	if data := read(t, s, pkg.Record{}, 0, 10); len(data) != 5 {
+		t.Errorf("read with an empty match expected 5 records, got %d", len(data))
+	}
+	if data := read(t, s, nil, 0, 3); len(data) != 3 {
+		t.Errorf("read with limit 3 expected 3 records, got %d", len(data))
+	}
+	if data := read(t, s, nil, 4, 3); len(data) != 1 {
+		t.Errorf("read with offset 4 limit 3 expected 1 record, got %d", len(data))
+	}
+	if data := read(t, s, nil, 5, 3); len(data) != 0 {
+		t.Errorf("read past the last record expected no records, got %d", len(data))
+	}
+
+	//paging through the resource visits every record once
+	seen := map[string]bool{}
+	for offset := 0; offset < 5; offset += 2 {
+		for _, record := range read(t, s, nil, offset, 2) {
+			seen[fmt.Sprint(record["id"])] = true
+		}
+	}
+	if len(seen) != 5 {
+		t.Errorf("paging with limit 2 expected 5 distinct records, got %d", len(seen))
+	}
+
+	if data := read(t, s, pkg.Record{"test_field_1": "value_3"}, 0, 10); len(data) != 1 || data[0]["test_field_1"] != "value_3" {
+		t.Errorf("read matching test_field_1 expected value_3, got %v", data)
+	}
+	if data := read(t, s, pkg.Record{"test_field_2": "seed"}, 1, 10); len(data) != 4 {
+		t.Errorf("read matching test_field_2 with offset 1 expected 4 records, got %d", len(data))
+	}
+	if data := read(t, s, pkg.Record{"test_field_1": "value_3", "test_field_2": "other"}, 0, 10); len(data) != 0 {
+		t.Errorf("read matching on every field expected no records, got %v", data)
+	}
+
+	id := idOf(t, s, "value_2")
+	if data := read(t, s, pkg.Record{"id": fmt.Sprint(id)}, 0, 1); len(data) != 1 || data[0]["test_field_1"] != "value_2" {
+		t.Errorf("read matching an id given as a string expected value_2, got %v", data)
+	}
+}
+
+func testFilters(t *testing.T, s pkg.Storage) {
+	seed(t, s, 5)
+
+	filtered := func(filters ...pkg.Filter) pkg.Records {
+		return readQuery(t, s, &pkg.Query{Filters: filters, Limit: 10})
+	}
+	expect := func(description string, data pkg.Records, values ...string) {
+		got := map[string]bool{}
+		for _, record := range data {
+			got[fmt.Sprint(record["test_field_1"])] = true
+		}
+		if len(data) != len(values) {
+			t.Errorf("%s expected %v, got %v", description, values, data)
+			return
+		}
+		for _, v := range values {
+			if !got[v] {
+				t.Errorf("%s expected %v, got %v", description, values, data)
+				return
+			}
+		}
+	}
+
+	expect("eq", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.Eq, Value: "value_1"}), "value_1")
+	expect("ne", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.Ne, Value: "value_1"}), "value_0", "value_2", "value_3", "value_4")
+	expect("gt", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.Gt, Value: "value_2"}), "value_3", "value_4")
+	expect("gte", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.Gte, Value: "value_2"}), "value_2", "value_3", "value_4")
+	expect("lt", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.Lt, Value: "value_2"}), "value_0", "value_1")
+	expect("lte", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.Lte, Value: "value_2"}), "value_0", "value_1", "value_2")
+	expect("like", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.Like, Value: "%_3"}), "value_3")
+	expect("in", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.In, Value: []interface{}{"value_1", "value_4", "other"}}), "value_1", "value_4")
+	expect("empty in", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.In, Value: []interface{}{}}))
+	expect("null", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.Null, Value: true}))
+	expect("not null", filtered(pkg.Filter{Column: "test_field_1", Operator: pkg.Null, Value: false}), "value_0", "value_1", "value_2", "value_3", "value_4")
+	expect("combined", filtered(
+		pkg.Filter{Column: "test_field_1", Operator: pkg.Gt, Value: "value_0"},
+		pkg.Filter{Column: "test_field_1", Operator: pkg.Lt, Value: "value_3"},
+	), "value_1", "value_2")
+
+	id := idOf(t, s, "value_2")
+	expect("numeric gt", filtered(pkg.Filter{Column: "id", Operator: pkg.Gt, Value: fmt.Sprint(id)}), "value_3", "value_4")
+
+	_, err := s.Read(context.Background(), Resource, &pkg.Query{Filters: []pkg.Filter{{Column: "test_field_1", Operator: "between", Value: "a"}}, Limit: 10})
+	if err == nil || err.Code != 400 {
+		t.Errorf("read with an unknown operator expected a 400, got %v", err)
+	}
+}
+
+func testSort(t *testing.T, s pkg.Storage) {
+	for _, v := range []string{"b", "a", "c", "a"} {
+		if _, err := s.Create(context.Background(), Resource, pkg.Record{"test_field_1": v, "test_field_2": "x" + v}); err != nil {
+			t.Fatalf("seeding failed: %v", err)
+		}
+	}
+	s.Update(context.Background(), Resource, pkg.Record{"id": fmt.Sprint(idOf(t, s, "c")), "test_field_2": "y"})
+
+	order := func(query *pkg.Query) string {
+		var values []string
+		for _, record := range readQuery(t, s, query) {
+			values = append(values, fmt.Sprint(record["test_field_1"]))
+		}
+		return fmt.Sprint(values)
+	}
+
+	if o := order(&pkg.Query{Sort: []pkg.Sort{{Column: "test_field_1"}}, Limit: 10}); o != "[a a b c]" {
+		t.Errorf("ascending sort expected [a a b c], got %s", o)
+	}
+	if o := order(&pkg.Query{Sort: []pkg.Sort{{Column: "test_field_1", Descending: true}}, Limit: 10}); o != "[c b a a]" {
+		t.Errorf("descending sort expected [c b a a], got %s", o)
+	}
+	if o := order(&pkg.Query{Sort: []pkg.Sort{{Column: "test_field_2", Descending: true}, {Column: "test_field_1"}}, Limit: 2, Offset: 1}); o != "[b a]" {
+		t.Errorf("multi key sort with offset expected [b a], got %s", o)
+	}
+
+	_, err := s.Read(context.Background(), Resource, &pkg.Query{Sort: []pkg.Sort{{Column: "not_a_column"}}, Limit: 10})
+	if err == nil || err.Code != 400 {
+		t.Errorf("sort by an unknown column expected a 400, got %v", err)
+	}
+}
+
+func testFields(t *testing.T, s pkg.Storage) {
+	seed(t, s, 2)
+
+	data := readQuery(t, s, &pkg.Query{Fields: []string{"id", "test_field_1"}, Limit: 10})
+	if len(data) != 2 {
+		t.Fatalf("read with fields expected 2 records, got %d", len(data))
+	}
+	for _, record := range data {
+		if len(record) != 2 || record["id"] == nil || record["test_field_1"] == nil {
+			t.Errorf("read with fields id,test_field_1 expected only those fields, got %v", record)
+		}
+	}
+
+	_, err := s.Read(context.Background(), Resource, &pkg.Query{Fields: []string{"id", "not_a_column"}, Limit: 10})
+	if err == nil || err.Code != 400 {
+		t.Errorf("read of an unknown field expected a 400, got %v", err)
+	}
+}
+
+func testAfter(t *testing.T, s pkg.Storage) {
+	seed(t, s, 5)
+	s.Update(context.Background(), Resource, pkg.Record{"id": fmt.Sprint(idOf(t, s, "value_3")), "test_field_2": "other"})
+
+	//keyset pages through the sort keys then id, the last values of each page lead to the next
+	pages := func(column string, descending bool) string {
+		keys := []pkg.Sort{{Column: column, Descending: descending}, {Column: "id"}}
+		var seen []string
+		var after []interface{}
+		for page := 0; page < 4; page++ {
+			data := readQuery(t, s, &pkg.Query{Sort: keys, After: after, Limit: 2})
+			for _, record := range data {
+				seen = append(seen, fmt.Sprint(record["test_field_1"]))
+			}
+			if len(data) < 2 {
+				break
+			}
+			last := data[len(data)-1]
+			after = []interface{}{last[column], fmt.Sprint(last["id"])}
+		}
+		return fmt.Sprint(seen)
+	}
+	if seen := pages("test_field_2", true); seen != "[value_0 value_1 value_2 value_4 value_3]" {
+		t.Errorf("paging after each cursor expected [value_0 value_1 value_2 value_4 value_3], got %v", seen)
+	}
+
+	//nulls sort before every value, a cursor holding one still leads to the records after it
+	s.Update(context.Background(), Resource, pkg.Record{"id": fmt.Sprint(idOf(t, s, "value_1")), "test_unique": "a"})
+	s.Update(context.Background(), Resource, pkg.Record{"id": fmt.Sprint(idOf(t, s, "value_3")), "test_unique": "b"})
+	if seen := pages("test_unique", false); seen != "[value_0 value_2 value_4 value_1 value_3]" {
+		t.Errorf("paging over nulls ascending expected [value_0 value_2 value_4 value_1 value_3], got %v", seen)
+	}
+	if seen := pages("test_unique", true); seen != "[value_3 value_1 value_0 value_2 value_4]" {
+		t.Errorf("paging over nulls descending expected [value_3 value_1 value_0 value_2 value_4], got %v", seen)
+	}
+
+	_, err := s.Read(context.Background(), Resource, &pkg.Query{Sort: []pkg.Sort{{Column: "test_field_2", Descending: true}, {Column: "id"}}, After: []interface{}{"seed"}, Limit: 2})
+	if err == nil || err.Code != 400 {
+		t.Errorf("read after a cursor not matching the sort expected a 400, got %v", err)
+	}
+}
+
+func testCount(t *testing.T, s pkg.Storage) {
+	seed(t, s, 5)
+
+	r, err := s.Read(context.Background(), Resource, &pkg.Query{Limit: 2, Offset: 1, Count: true, Filters: []pkg.Filter{{Column: "test_field_1", Operator: pkg.Ne, Value: "value_0"}}})
+	if err != nil {
+		t.Fatalf("read with a count failed: %v", err)
+	}
+	if len(r.Data) != 2 || r.Meta == nil || r.Meta.Total != 4 {
+		t.Errorf("read with a count expected 2 records of 4, got %d records and meta %+v", len(r.Data), r.Meta)
+	}
+
+	r, _ = s.Read(context.Background(), Resource, &pkg.Query{Limit: 2})
+	if r.Meta != nil {
+		t.Errorf("read without a count expected no meta, got %+v", r.Meta)
+	}
+}
+
+func testUpdate(t *testing.T, s pkg.Storage) {
+	seed(t, s, 2)
+	id := idOf(t, s, "value_0")
+
+	r, err := s.Update(context.Background(), Resource, pkg.Record{"id": fmt.Sprint(id), "test_field_1": "updated"})
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if r.Updated != 1 {
+		t.Errorf("update reported %d updated, expected 1", r.Updated)
+	}
+
+	data := read(t, s, pkg.Record{"id": fmt.Sprint(id)}, 0, 1)
+	if len(data) != 1 || data[0]["test_field_1"] != "updated" || data[0]["test_field_2"] != "seed" {
+		t.Errorf("update not reflected by read, got %v", data)
+	}
+	if data := read(t, s, pkg.Record{"test_field_1": "value_1"}, 0, 10); len(data) != 1 {
+		t.Errorf("update touched a record it did not match")
+	}
+
+	r, err = s.Update(context.Background(), Resource, pkg.Record{"id": "999999", "test_field_1": "updated"})
+	if err != nil {
+		t.Fatalf("update of an unknown id failed: %v", err)
+	}
+	if r.Updated != 0 {
+		t.Errorf("update of an unknown id reported %d updated, expected 0", r.Updated)
+	}
+
+	_, err = s.Update(context.Background(), Resource, pkg.Record{"id": fmt.Sprint(id)})
+	if err == nil || err.Code != 400 {
+		t.Errorf("update of nothing but the id expected a 400, got %v", err)
+	}
+	_, err = s.Update(context.Background(), Resource, pkg.Record{"test_field_1": "updated"})
+	if err == nil || err.Code != 400 {
+		t.Errorf("update without an id expected a 400, got %v", err)
+	}
+}
+
+func testUpsert(t *testing.T, s pkg.Storage) {
+	seed(t, s, 1)
+
+	r, err := s.Upsert(context.Background(), Resource, pkg.Record{"id": "1000", "test_field_1": "upserted", "test_field_2": "new"})
+	if err != nil {
+		t.Fatalf("upsert of a new id failed: %v", err)
+	}
+	if r.Created != 1 || r.Updated != 0 {
+		t.Errorf("upsert of a new id reported %d created and %d updated, expected 1 and 0", r.Created, r.Updated)
+	}
+	if len(r.Data) != 1 || fmt.Sprint(r.Data[0]["id"]) != "1000" {
+		t.Errorf("upsert of a new id expected the created record, got %v", r.Data)
+	}
+	data := read(t, s, pkg.Record{"id": "1000"}, 0, 1)
+	if len(data) != 1 || data[0]["test_field_1"] != "upserted" || data[0]["test_field_2"] != "new" {
+		t.Errorf("upserted record not read back at its id, got %v", data)
+	}
+
+	r, err = s.Upsert(context.Background(), Resource, pkg.Record{"id": "1000", "test_field_1": "replaced", "test_field_2": "new"})
+	if err != nil {
+		t.Fatalf("upsert of an existing id failed: %v", err)
+	}
+	if r.Created != 0 || r.Updated != 1 {
+		t.Errorf("upsert of an existing id reported %d created and %d updated, expected 0 and 1", r.Created, r.Updated)
+	}
+	data = read(t, s, pkg.Record{"id": "1000"}, 0, 1)
+	if len(data) != 1 || data[0]["test_field_1"] != "replaced" {
+		t.Errorf("upsert not reflected by read, got %v", data)
+	}
+	if data := read(t, s, nil, 0, 10); len(data) != 2 {
+		t.Errorf("upserts expected 2 records in all, got %d", len(data))
+	}
+
+	//the key alone decides between an insert and an update, clashing on another unique column is a conflict
+	if _, err = s.Update(context.Background(), Resource, pkg.Record{"id": "1000", "test_unique": "taken"}); err != nil {
+		t.Fatalf("update of a unique value failed: %v", err)
+	}
+	_, err = s.Upsert(context.Background(), Resource, pkg.Record{"id": "1001", "test_field_1": "clash", "test_field_2": "new", "test_unique": "taken"})
+	if err == nil || err.Code != 409 {
+		t.Errorf("upsert of a new id with a taken unique value expected a 409, got %v", err)
+	}
+	_, err = s.Upsert(context.Background(), Resource, pkg.Record{"id": fmt.Sprint(idOf(t, s, "value_0")), "test_unique": "taken"})
+	if err == nil || err.Code != 409 {
+		t.Errorf("upsert of an existing id with a taken unique value expected a 409, got %v", err)
+	}
+	data = read(t, s, pkg.Record{"test_unique": "taken"}, 0, 10)
+	if len(data) != 1 || fmt.Sprint(data[0]["id"]) != "1000" || data[0]["test_field_1"] != "replaced" {
+		t.Errorf("a conflicting upsert expected the holder of the unique value untouched, got %v", data)
+	}
+	if data := read(t, s, nil, 0, 10); len(data) != 2 {
+		t.Errorf("conflicting upserts expected 2 records in all, got %d", len(data))
+	}
+
+	_, err = s.Upsert(context.Background(), Resource, pkg.Record{"id": "1001", "test_field_1": "a"})
+	if err == nil || err.Code != 400 {
+		t.Errorf("upsert of a new id without a required value expected a 400, got %v", err)
+	}
+	_, err = s.Upsert(context.Background(), Resource, pkg.Record{"test_field_1": "a", "test_field_2": "b"})
+	if err == nil || err.Code != 400 {
+		t.Errorf("upsert without an id expected a 400, got %v", err)
+	}
+
+	createAfterExplicit(t, s, "1000")
+}
+
+func testDelete(t *testing.T, s pkg.Storage) {
+	seed(t, s, 2)
+	id := idOf(t, s, "value_0")
+
+	r, err := s.Delete(context.Background(), Resource, pkg.Record{"id": fmt.Sprint(id)})
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if r.Deleted != 1 {
+		t.Errorf("delete reported %d deleted, expected 1", r.Deleted)
+	}
+	if data := read(t, s, nil, 0, 10); len(data) != 1 || data[0]["test_field_1"] != "value_1" {
+		t.Errorf("delete not reflected by read, got %v", data)
+	}
+
+	r, err = s.Delete(context.Background(), Resource, pkg.Record{"id": fmt.Sprint(id)})
+	if err != nil {
+		t.Fatalf("repeated delete failed: %v", err)
+	}
+	if r.Deleted != 0 {
+		t.Errorf("repeated delete reported %d deleted, expected 0", r.Deleted)
+	}
+}
+
+//writes narrowed by where filters only touch rows meeting them, as if the others didn't exist
+func testWhere(t *testing.T, s pkg.Storage) {
+	seed(t, s, 2)
+	first := fmt.Sprint(idOf(t, s, "value_0"))
+	second := fmt.Sprint(idOf(t, s, "value_1"))
+	where := pkg.Filter{Column: "test_field_1", Operator: pkg.Eq, Value: "value_0"}
+
+	if r, err := s.Update(context.Background(), Resource, pkg.Record{"id": second, "test_field_2": "changed"}, where); err != nil || r.Updated != 0 {
+		t.Errorf("update of a row not meeting the filters expected nothing updated, got %v %v", r, err)
+	}
+	if r, err := s.Update(context.Background(), Resource, pkg.Record{"id": first, "test_field_2": "changed"}, where); err != nil || r.Updated != 1 {
+		t.Errorf("update of a row meeting the filters expected 1 updated, got %v %v", r, err)
+	}
+
+	if _, err := s.Upsert(context.Background(), Resource, pkg.Record{"id": second, "test_field_2": "changed"}, where); err == nil || err.Code != 404 {
+		t.Errorf("upsert of a row not meeting the filters expected a 404, got %v", err)
+	}
+	if r, err := s.Upsert(context.Background(), Resource, pkg.Record{"id": first, "test_field_2": "upserted"}, where); err != nil || r.Updated != 1 {
+		t.Errorf("upsert of a row meeting the filters expected 1 updated, got %v %v", r, err)
+	}
+
+	r, err := s.BulkUpdate(context.Background(), Resource, pkg.Records{{"id": first, "test_field_2": "bulk"}, {"id": second, "test_field_2": "bulk"}}, where)
+	if err != nil {
+		t.Fatalf("bulk update with filters failed: %v", err)
+	}
+	if r.Updated != 1 || len(r.Items) != 2 || r.Items[0].Status != 200 || r.Items[1].Status != 404 {
+		t.Errorf("bulk update with filters expected only the row meeting them updated, got %d and %v", r.Updated, r.Items)
+	}
+
+	if r, err := s.Delete(context.Background(), Resource, pkg.Record{"id": second}, where); err != nil || r.Deleted != 0 {
+		t.Errorf("delete of a row not meeting the filters expected nothing deleted, got %v %v", r, err)
+	}
+	if data := read(t, s, pkg.Record{"id": second}, 0, 1); len(data) != 1 || data[0]["test_field_2"] != "seed" {
+		t.Errorf("a row not meeting the filters expected untouched, got %v", data)
+	}
+	if data := read(t, s, pkg.Record{"id": first}, 0, 1); len(data) != 1 || data[0]["test_field_2"] != "bulk" {
+		t.Errorf("writes to a row meeting the filters not reflected by read, got %v", data)
+	}
+	if r, err := s.Delete(context.Background(), Resource, pkg.Record{"id": first}, where); err != nil || r.Deleted != 1 {
+		t.Errorf("delete of a row meeting the filters expected 1 deleted, got %v %v", r, err)
+	}
+}
+
+func testBulkCreate(t *testing.T, s pkg.Storage) {
+	records := pkg.Records{}
+	for i := 0; i < 5; i++ {
+		records = append(records, pkg.Record{"test_field_1": fmt.Sprintf("bulk_%d", i), "test_field_2": "bulk"})
+	}
+	records = append(records, pkg.Record{"id": "1000", "test_field_1": "bulk_5", "test_field_2": "bulk"})
+
+	r, err := s.BulkCreate(context.Background(), Resource, records)
+	if err != nil {
+		t.Fatalf("bulk create failed: %v", err)
+	}
+	if r.Created != 6 || len(r.Items) != 6 || len(r.Data) != 6 {
+		t.Fatalf("bulk create expected 6 created records and items, got %d, %v and %v", r.Created, r.Data, r.Items)
+	}
+	for i, item := range r.Items {
+		if item.Status != 201 || item.Id == nil {
+			t.Errorf("bulk create item %d expected a 201 with an id, got %+v", i, item)
+			continue
+		}
+		if r.Data[i]["test_field_1"] != fmt.Sprintf("bulk_%d", i) || fmt.Sprint(r.Data[i]["id"]) != fmt.Sprint(item.Id) {
+			t.Errorf("bulk create record %d does not match its item, got %v and %+v", i, r.Data[i], item)
+		}
+		if data := read(t, s, pkg.Record{"id": fmt.Sprint(item.Id)}, 0, 1); len(data) != 1 || data[0]["test_field_1"] != fmt.Sprintf("bulk_%d", i) {
+			t.Errorf("bulk created record %d not read back at its id, got %v", i, data)
+		}
+	}
+
+	createAfterExplicit(t, s, "1000")
+
+	_, err = s.BulkCreate(context.Background(), Resource, pkg.Records{{"test_field_1": "a", "test_field_2": "b"}, {"test_field_1": "a"}})
+	if err == nil || err.Code != 400 {
+		t.Errorf("bulk create with a record missing a required value expected a 400, got %v", err)
+	}
+	_, err = s.BulkCreate(context.Background(), Resource, pkg.Records{{"test_field_1": "a", "test_field_2": "b"}, {"test_field_1": "a", "not_a_column": "c"}})
+	if err == nil || err.Code != 400 {
+		t.Errorf("bulk create with an unknown column expected a 400, got %v", err)
+	}
+	if data := read(t, s, nil, 0, 10); len(data) != 7 {
+		t.Errorf("a failed bulk create expected nothing created, got %d records", len(data))
+	}
+}
+
+func testBulkUpdate(t *testing.T, s pkg.Storage) {
+	seed(t, s, 3)
+	first := fmt.Sprint(idOf(t, s, "value_0"))
+	second := fmt.Sprint(idOf(t, s, "value_1"))
+
+	r, err := s.BulkUpdate(context.Background(), Resource, pkg.Records{
+		{"id": first, "test_field_1": "bulk_0"},
+		{"id": second, "test_field_2": "bulk_1"},
+		{"id": "999999", "test_field_1": "missing"},
+	})
+	if err != nil {
+		t.Fatalf("bulk update failed: %v", err)
+	}
+	if r.Updated != 2 || len(r.Items) != 3 {
+		t.Fatalf("bulk update expected 2 updated and 3 items, got %d and %v", r.Updated, r.Items)
+	}
+	if r.Items[0].Status != 200 || r.Items[1].Status != 200 || r.Items[2].Status != 404 {
+		t.Errorf("bulk update expected item statuses 200, 200 and 404, got %v", r.Items)
+	}
+
+	data := read(t, s, pkg.Record{"id": first}, 0, 1)
+	if len(data) != 1 || data[0]["test_field_1"] != "bulk_0" || data[0]["test_field_2"] != "seed" {
+		t.Errorf("bulk update of the first record not reflected by read, got %v", data)
+	}
+	data = read(t, s, pkg.Record{"id": second}, 0, 1)
+	if len(data) != 1 || data[0]["test_field_1"] != "value_1" || data[0]["test_field_2"] != "bulk_1" {
+		t.Errorf("bulk update of the second record not reflected by read, got %v", data)
+	}
+	if data := read(t, s, pkg.Record{"test_field_1": "value_2"}, 0, 10); len(data) != 1 || data[0]["test_field_2"] != "seed" {
+		t.Errorf("bulk update touched a record it was not given, got %v", data)
+	}
+
+	_, err = s.BulkUpdate(context.Background(), Resource, pkg.Records{{"id": first, "test_field_1": "a"}, {"test_field_1": "b"}})
+	if err == nil || err.Code != 400 {
+		t.Errorf("bulk update of a record without an id expected a 400, got %v", err)
+	}
+}
+
+func testBulkDelete(t *testing.T, s pkg.Storage) {
+	seed(t, s, 4)
+	first := fmt.Sprint(idOf(t, s, "value_0"))
+	second := fmt.Sprint(idOf(t, s, "value_1"))
+
+	r, err := s.BulkDelete(context.Background(), Resource, &pkg.Query{Filters: []pkg.Filter{{Column: "id", Operator: pkg.In, Value: []interface{}{first, second, "999999"}}}})
+	if err != nil {
+		t.Fatalf("bulk delete failed: %v", err)
+	}
+	if r.Deleted != 2 || len(r.Items) != 2 {
+		t.Errorf("bulk delete expected 2 deleted records and items, got %d and %v", r.Deleted, r.Items)
+	}
+	if data := read(t, s, nil, 0, 10); len(data) != 2 {
+		t.Errorf("bulk delete not reflected by read, got %v", data)
+	}
+
+	_, err = s.BulkDelete(context.Background(), Resource, &pkg.Query{})
+	if err == nil || err.Code != 400 {
+		t.Errorf("bulk delete without a filter expected a 400, got %v", err)
+	}
+}
+
+//the storage itself isn't used while a transaction is open, a backend may serialise them
+func testTransaction(t *testing.T, s pkg.Storage) {
+	seed(t, s, 1)
+	id := fmt.Sprint(idOf(t, s, "value_0"))
+
+	tx, err := s.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	if _, err = tx.Create(context.Background(), Resource, pkg.Record{"test_field_1": "rolled_back", "test_field_2": "tx"}); err != nil {
+		t.Fatalf("create within a transaction failed: %v", err)
+	}
+	if data := readQuery(t, tx, pkg.MatchQuery(pkg.Record{"test_field_2": "tx"}, 0, 10)); len(data) != 1 {
+		t.Errorf("a transaction expected to read its own write, got %v", data)
+	}
+	if _, err := tx.Begin(context.Background()); err == nil {
+		t.Errorf("a transaction expected to refuse a nested one")
+	}
+	if err = tx.Rollback(); err != nil {
+		t.Fatalf("rollback failed: %v", err)
+	}
+	tx.Close()
+	if data := read(t, s, pkg.Record{"test_field_2": "tx"}, 0, 10); len(data) != 0 {
+		t.Errorf("rolled back write expected to be discarded, got %v", data)
+	}
+
+	tx, err = s.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	if _, err = tx.Create(context.Background(), Resource, pkg.Record{"test_field_1": "committed", "test_field_2": "tx"}); err != nil {
+		t.Fatalf("create within a transaction failed: %v", err)
+	}
+	if _, err = tx.Update(context.Background(), Resource, pkg.Record{"id": id, "test_field_2": "tx"}); err != nil {
+		t.Fatalf("update within a transaction failed: %v", err)
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatalf("commit failed: %v", err)
+	}
+	tx.Close()
+	if data := read(t, s, pkg.Record{"test_field_2": "tx"}, 0, 10); len(data) != 2 {
+		t.Errorf("committed writes expected to be kept, got %v", data)
+	}
+
+	tx, err = s.Begin(context.Background())
+	if err != nil {
+		t.Fatalf("begin failed: %v", err)
+	}
+	tx.Create(context.Background(), Resource, pkg.Record{"test_field_1": "closed", "test_field_2": "tx"})
+	tx.Close()
+	if data := read(t, s, pkg.Record{"test_field_2": "tx"}, 0, 10); len(data) != 2 {
+		t.Errorf("closing a transaction expected to roll it back, got %v", data)
+	}
+}
+
+func testContext(t *testing.T, s pkg.Storage) {
+	seed(t, s, 1)
+
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err := s.Read(expired, Resource, &pkg.Query{Limit: 10})
+	if err == nil || err.Code != 504 {
+		t.Errorf("read past its deadline expected a 504, got %v", err)
+	}
+	_, err = s.Create(expired, Resource, pkg.Record{"test_field_1": "late", "test_field_2": "late"})
+	if err == nil || err.Code != 504 {
+		t.Errorf("create past its deadline expected a 504, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = s.Update(cancelled, Resource, pkg.Record{"id": fmt.Sprint(idOf(t, s, "value_0")), "test_field_1": "cancelled"})
+	if err == nil {
+		t.Errorf("update with a cancelled context expected an error")
+	}
+
+	if data := read(t, s, nil, 0, 10); len(data) != 1 || data[0]["test_field_1"] != "value_0" {
+		t.Errorf("operations with an ended context expected to change nothing, got %v", data)
+	}
+}
+
+func testMissingResource(t *testing.T, s pkg.Storage) {
+	expect404 := func(operation string, err *pkg.StorageError) {
+		if err == nil || err.Code != 404 {
+			t.Errorf("%s of a missing resource expected a 404, got %v", operation, err)
+		}
+	}
+
+	_, err := s.Create(context.Background(), Missing, pkg.Record{"test_field_1": "a", "test_field_2": "b"})
+	expect404("create", err)
+	_, err = s.Read(context.Background(), Missing, &pkg.Query{Limit: 10})
+	expect404("read", err)
+	_, err = s.Update(context.Background(), Missing, pkg.Record{"id": "1", "test_field_1": "a"})
+	expect404("update", err)
+	_, err = s.Upsert(context.Background(), Missing, pkg.Record{"id": "1", "test_field_1": "a", "test_field_2": "b"})
+	expect404("upsert", err)
+	_, err = s.Delete(context.Background(), Missing, pkg.Record{"id": "1"})
+	expect404("delete", err)
+	_, err = s.Describe(Missing)
+	expect404("describe", err)
+}
+
+func testUnknownColumn(t *testing.T, s pkg.Storage) {
+	seed(t, s, 1)
+	id := fmt.Sprint(idOf(t, s, "value_0"))
+	expect400 := func(operation string, err *pkg.StorageError) {
+		if err == nil || err.Code != 400 {
+			t.Errorf("%s with an unknown column expected a 400, got %v", operation, err)
+		}
+	}
+
+	_, err := s.Create(context.Background(), Resource, pkg.Record{"test_field_1": "a", "test_field_2": "b", "not_a_column": "c"})
+	expect400("create", err)
+	_, err = s.Read(context.Background(), Resource, pkg.MatchQuery(pkg.Record{"not_a_column": "c"}, 0, 10))
+	expect400("read", err)
+	_, err = s.Update(context.Background(), Resource, pkg.Record{"id": id, "not_a_column": "c"})
+	expect400("update", err)
+	_, err = s.Upsert(context.Background(), Resource, pkg.Record{"id": id, "not_a_column": "c"})
+	expect400("upsert", err)
+
+	//identifiers are data, never sql
+	_, err = s.Read(context.Background(), Resource, pkg.MatchQuery(pkg.Record{"1=1 OR test_field_1": "c"}, 0, 10))
+	expect400("read", err)
+	_, err = s.Read(context.Background(), pkg.Resource{Identifier: Resource.Identifier + "; DROP TABLE " + Resource.Identifier}, &pkg.Query{Limit: 10})
+	if err == nil || err.Code != 404 {
+		t.Errorf("read of an injected resource expected a 404, got %v", err)
+	}
+}
+
+func testDescribe(t *testing.T, s pkg.Storage) {
+	table, err := s.Describe(Resource)
+	if err != nil {
+		t.Fatalf("describe failed: %v", err)
+	}
+	if len(table.Key) != 1 || table.Key[0] != "id" {
+		t.Errorf("describe expected the key [id], got %v", table.Key)
+	}
+	for _, c := range []string{"id", "test_field_1", "test_field_2"} {
+		found := false
+		for _, column := range table.Columns {
+			found = found || column == c
+		}
+		if !found {
+			t.Errorf("describe expected the column %s, got %v", c, table.Columns)
+		}
+	}
+}